@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	categorymatcher "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/categoryMatcher"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/importer"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
+	"github.com/google/uuid"
+)
+
+// Usage: expenses-save-api import -user <user id> -sheet <sheet id> -name <sheet name> [-dry-run] [-sync] [-report <file>]
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	userIDStr := flags.String("user", "", "ID of the user the expenses are imported for")
+	sheetID := flags.String("sheet", "", "ID of the spreadsheet to import")
+	sheetName := flags.String("name", "", "name of the sheet inside the spreadsheet")
+	hasHeader := flags.Bool("header", true, "the first row of the sheet is a header")
+	dryRun := flags.Bool("dry-run", false, "only preview the import, nothing is inserted")
+	sync := flags.Bool("sync", false, "also send the imported expenses to the user destinations")
+	reportPath := flags.String("report", "", "write the rows that could not be imported as CSV to this file instead of stdout")
+	flags.Parse(args)
+
+	userID, err := uuid.Parse(*userIDStr)
+	if err != nil {
+		log.Fatalf("invalid user ID: %v", err)
+	}
+
+	if *sheetID == "" || *sheetName == "" {
+		log.Fatal("-sheet and -name are required")
+	}
+
+	dbService, err := database.NewDatabaseService()
+	if err != nil {
+		log.Fatalf("unable to start database service: %v", err)
+	}
+	defer dbService.Close()
+
+	dollarService := dollar.NewDollarService(repository.NewExchangeRateRepository(dbService), repository.NewCurrencyRateRepository(dbService))
+
+	sheetsService, err := sheets.NewSheetsService()
+	if err != nil {
+		log.Fatalf("unable to retrieve Sheets client: %v", err)
+	}
+
+	userPreferenceRepo := repository.NewUserPreferenceRepository(dbService)
+
+	expenseValidatorService, err := validator.NewExpenseValidatorService(repository.NewReferenceRepository(dbService), repository.NewPaymentMethodRepository(dbService), userPreferenceRepo, repository.NewCurrencyRepository(dbService), dollarService, categorymatcher.NewCategoryMatcher(repository.NewCategoryRuleRepository(dbService)))
+	if err != nil {
+		log.Fatalf("unable to start expense validator service: %v", err)
+	}
+
+	importService := importer.NewImportService(
+		dbService,
+		repository.NewExpenseRepository(dbService),
+		repository.NewExpenseSyncRepository(dbService),
+		userPreferenceRepo,
+		expenseValidatorService,
+		sheetsService,
+	)
+
+	result, err := importService.Import(context.Background(), userID, &importer.Options{
+		SheetID:   *sheetID,
+		SheetName: *sheetName,
+		HasHeader: *hasHeader,
+		DryRun:    *dryRun,
+		Sync:      *sync,
+	})
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+
+	if *dryRun {
+		for _, row := range result.Imported {
+			e := row.Expense
+			fmt.Printf("row %d: %s %s ARS %s USD %s\n", row.RowNumber, e.Date.Format("2006-01-02"), e.Description, e.ARSAmount, e.USDAmount)
+		}
+	}
+
+	report := io.Writer(os.Stdout)
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatalf("unable to create report file: %v", err)
+		}
+		defer f.Close()
+		report = f
+	}
+
+	if len(result.Unresolved) > 0 {
+		if err := writeUnresolved(report, result.Unresolved); err != nil {
+			log.Fatalf("unable to write report: %v", err)
+		}
+	}
+
+	action := "imported"
+	if *dryRun {
+		action = "would be imported"
+	}
+	log.Printf("%d rows %s, %d rows could not be resolved", len(result.Imported), action, len(result.Unresolved))
+}
+
+func writeUnresolved(w io.Writer, rows []importer.UnresolvedRow) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"row", "reason", "date", "description", "paymentMethod", "ars", "usd", "category", "subcategory"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := append([]string{strconv.Itoa(row.RowNumber), row.Reason}, row.Values...)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
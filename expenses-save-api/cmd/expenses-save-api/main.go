@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"strings"
 
+	categorymatcher "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/categoryMatcher"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/consumer"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/destination"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+	expensesync "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/expenseSync"
 	grpcserver "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/grpcServer"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/alias"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/category"
+	categoryrule "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/categoryRule"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/fx"
 	paymentmethod "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/paymentMethod"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/preference"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/revaluation"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
 )
@@ -19,9 +31,20 @@ import (
 func main() {
 	env.LoadEnv()
 
-	dollarService, err := dollar.NewDollarService()
-	if err != nil {
-		log.Fatalf("unable to start dollar service: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			runReconcile(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "revalue":
+			runRevalue(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command %s", os.Args[1])
+		}
 	}
 
 	dbService, err := database.NewDatabaseService()
@@ -30,18 +53,16 @@ func main() {
 	}
 	defer dbService.Close()
 
+	exchangeRateRepo := repository.NewExchangeRateRepository(dbService)
+	currencyRateRepo := repository.NewCurrencyRateRepository(dbService)
+
+	dollarService := dollar.NewDollarService(exchangeRateRepo, currencyRateRepo)
+
 	sheetsService, err := sheets.NewSheetsService()
 	if err != nil {
 		log.Fatalf("unable to retrieve Sheets client: %v", err)
 	}
 
-	expenseValidatorService, err := validator.NewExpenseValidatorService(dbService, dollarService)
-	if err != nil {
-		log.Fatalf("unable to start expense validator service: %v", err)
-	}
-
-	grpcServer := grpcserver.NewGrpcServer(sheetsService, dbService, expenseValidatorService)
-
 	// Rpositories
 	categoryRepo := repository.NewCategoryRepository(dbService)
 	subcategoryRepo := repository.NewSubcategoryRepository(dbService)
@@ -49,20 +70,65 @@ func main() {
 	recurrentExpenseRepo := repository.NewRecurrentExpenseRepository(dbService)
 	expenseRepo := repository.NewExpenseRepository(dbService)
 	installmentExpenseRepo := repository.NewInstallmentExpenseRepository(dbService)
+	expenseSyncRepo := repository.NewExpenseSyncRepository(dbService)
+	userExpenseSaveRepo := repository.NewUserExpenseSaveRepository(dbService)
+	userRepo := repository.NewUserRepository(dbService)
+	categoryRuleRepo := repository.NewCategoryRuleRepository(dbService)
+	nameAliasRepo := repository.NewNameAliasRepository(dbService)
+	referenceRepo := repository.NewReferenceRepository(dbService)
+	userPreferenceRepo := repository.NewUserPreferenceRepository(dbService)
+	currencyRepo := repository.NewCurrencyRepository(dbService)
+	expenseRevaluationRepo := repository.NewExpenseRevaluationRepository(dbService)
+
+	categoryMatcher := categorymatcher.NewCategoryMatcher(categoryRuleRepo)
+
+	expenseValidatorService, err := validator.NewExpenseValidatorService(referenceRepo, paymentMethodRepo, userPreferenceRepo, currencyRepo, dollarService, categoryMatcher)
+	if err != nil {
+		log.Fatalf("unable to start expense validator service: %v", err)
+	}
+
+	destinationRegistry := destination.NewRegistry(
+		destination.NewGoogleSheetsDestination(sheetsService),
+	)
+
+	expenseSyncWorker := expensesync.NewExpenseSyncWorker(expenseSyncRepo, userExpenseSaveRepo, expenseRepo, destinationRegistry)
+	rateCollector := dollar.NewRateCollector(dollarService, exchangeRateRepo, currencyRateRepo, currencyRepo)
 
 	// Services
 	categoryService := category.NewCategoryService(categoryRepo)
-	expenseService := expense.NewExpenseService(categoryRepo, subcategoryRepo, paymentMethodRepo, recurrentExpenseRepo, expenseRepo, installmentExpenseRepo, dollarService, dbService)
+	expenseService := expense.NewExpenseService(categoryRepo, subcategoryRepo, paymentMethodRepo, recurrentExpenseRepo, expenseRepo, installmentExpenseRepo, expenseSyncRepo, userPreferenceRepo, currencyRepo, expenseSyncWorker, dollarService, dbService)
 	paymentMethodService := paymentmethod.NewPaymentMethodService(paymentMethodRepo)
+	categoryRuleService := categoryrule.NewCategoryRuleService(categoryRuleRepo, expenseRepo, userPreferenceRepo)
+	aliasService := alias.NewAliasService(nameAliasRepo)
+	preferenceService := preference.NewPreferenceService(userPreferenceRepo, paymentMethodRepo, currencyRepo)
+	fxService := fx.NewFxService(dollarService, exchangeRateRepo)
+	revaluationService := revaluation.NewRevaluationService(dbService, expenseRepo, expenseRevaluationRepo, expenseSyncRepo, paymentMethodRepo, userPreferenceRepo, dollarService, expenseSyncWorker)
+
+	grpcServer := grpcserver.NewGrpcServer(expenseValidatorService, expenseService, preferenceService, userRepo)
 
 	// Controllers
 	categoryController := category.NewCategoryController(categoryService)
-	expenseController := expense.NewExpenseController(expenseService)
+	expenseController := expense.NewExpenseController(expenseService, revaluationService)
 	paymentMethodController := paymentmethod.NewPaymentMethodController(paymentMethodService)
+	categoryRuleController := categoryrule.NewCategoryRuleController(categoryRuleService)
+	aliasController := alias.NewAliasController(aliasService)
+	preferenceController := preference.NewPreferenceController(preferenceService)
+	fxController := fx.NewFxController(fxService)
 
-	httpServer := http.NewHttpServer(dbService, categoryController, expenseController, paymentMethodController)
+	httpServer := http.NewHttpServer(dbService, categoryController, expenseController, paymentMethodController, categoryRuleController, aliasController, preferenceController, fxController)
 	httpServer.RegisterRouter()
 
+	go expenseSyncWorker.Start(context.Background())
+	go rateCollector.Start(context.Background())
+
+	if len(*env.KAFKA_BROKERS) > 0 {
+		broker := consumer.NewKafkaBroker(strings.Split(*env.KAFKA_BROKERS, ","), *env.KAFKA_GROUP_ID, consumer.NotificationTopic)
+		notificationConsumer := consumer.NewNotificationConsumer(broker, expenseValidatorService, expenseService, userRepo, userPreferenceRepo, *env.KAFKA_DEFAULT_CATEGORY)
+
+		log.Printf("consuming %s from %s", consumer.NotificationTopic, *env.KAFKA_BROKERS)
+		go notificationConsumer.Start(context.Background())
+	}
+
 	go func() {
 		log.Printf("starting gRPC server on port %s", *env.GRPC_PORT)
 		grpcServer.Start()
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/reconcile"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
+	"github.com/google/uuid"
+)
+
+// Usage: expenses-save-api reconcile -user <user id> [-repair]
+func runReconcile(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	userIDStr := flags.String("user", "", "ID of the user whose sheets are reconciled")
+	repair := flags.Bool("repair", false, "fix the sheet after reporting the differences")
+	flags.Parse(args)
+
+	userID, err := uuid.Parse(*userIDStr)
+	if err != nil {
+		log.Fatalf("invalid user ID: %v", err)
+	}
+
+	dbService, err := database.NewDatabaseService()
+	if err != nil {
+		log.Fatalf("unable to start database service: %v", err)
+	}
+	defer dbService.Close()
+
+	sheetsService, err := sheets.NewSheetsService()
+	if err != nil {
+		log.Fatalf("unable to retrieve Sheets client: %v", err)
+	}
+
+	reconcileService := reconcile.NewReconcileService(dbService, repository.NewExpenseRepository(dbService), sheetsService)
+
+	reports, err := reconcileService.Reconcile(context.Background(), userID, *repair)
+	for _, report := range reports {
+		printReport(report)
+	}
+
+	if err != nil {
+		log.Fatalf("reconcile failed: %v", err)
+	}
+
+	for _, report := range reports {
+		if !report.InSync() && !*repair {
+			os.Exit(1)
+		}
+	}
+}
+
+func printReport(report *reconcile.Report) {
+	fmt.Printf("sheet %s (%s), destination %s\n", report.SheetName, report.SheetID, report.DestinationID)
+
+	if report.InSync() {
+		fmt.Println("  in sync")
+		return
+	}
+
+	for _, id := range report.Missing {
+		fmt.Printf("  missing     %s\n", id)
+	}
+
+	for _, extra := range report.Extra {
+		fmt.Printf("  extra       %s (row %d)\n", extra.ExpenseID, extra.RowNumber)
+	}
+
+	for _, mismatch := range report.Mismatched {
+		fmt.Printf("  mismatched  %s (row %d): %v\n", mismatch.ExpenseID, mismatch.RowNumber, mismatch.Fields)
+	}
+
+	fmt.Printf("  %d missing, %d extra, %d mismatched\n", len(report.Missing), len(report.Extra), len(report.Mismatched))
+}
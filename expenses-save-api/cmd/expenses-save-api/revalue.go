@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/revaluation"
+	"github.com/google/uuid"
+)
+
+// Usage: expenses-save-api revalue -user <user id> [-from <date>] [-to <date>] [-invalid] [-dry-run]
+func runRevalue(args []string) {
+	flags := flag.NewFlagSet("revalue", flag.ExitOnError)
+	userIDStr := flags.String("user", "", "ID of the user whose expenses are revalued")
+	from := flags.String("from", "", "first day of the expenses to revalue")
+	to := flags.String("to", "", "last day of the expenses to revalue")
+	onlyInvalid := flags.Bool("invalid", false, "only revalue expenses with a zero ARS or USD amount")
+	dryRun := flags.Bool("dry-run", false, "only print the changes, nothing is saved")
+	flags.Parse(args)
+
+	userID, err := uuid.Parse(*userIDStr)
+	if err != nil {
+		log.Fatalf("invalid user ID: %v", err)
+	}
+
+	dbService, err := database.NewDatabaseService()
+	if err != nil {
+		log.Fatalf("unable to start database service: %v", err)
+	}
+	defer dbService.Close()
+
+	dollarService := dollar.NewDollarService(repository.NewExchangeRateRepository(dbService), repository.NewCurrencyRateRepository(dbService))
+
+	// The syncs are sent by the server worker
+	revaluationService := revaluation.NewRevaluationService(
+		dbService,
+		repository.NewExpenseRepository(dbService),
+		repository.NewExpenseRevaluationRepository(dbService),
+		repository.NewExpenseSyncRepository(dbService),
+		repository.NewPaymentMethodRepository(dbService),
+		repository.NewUserPreferenceRepository(dbService),
+		dollarService,
+		nil,
+	)
+
+	result, err := revaluationService.Revalue(context.Background(), userID, &revaluation.Options{
+		StartDate:   *from,
+		EndDate:     *to,
+		OnlyInvalid: *onlyInvalid,
+		DryRun:      *dryRun,
+	})
+	if err != nil {
+		log.Fatalf("revaluation failed: %v", err)
+	}
+
+	for _, change := range result.Changes {
+		fmt.Printf(
+			"%s %s %s: ARS %s -> %s, USD %s -> %s (%s %.2f from %s)\n",
+			change.ExpenseID,
+			change.Date.Format("2006-01-02"),
+			change.Description,
+			change.OldARSAmount,
+			change.ARSAmount,
+			change.OldUSDAmount,
+			change.USDAmount,
+			change.RateType,
+			change.UsdArsRate,
+			change.RateProvider,
+		)
+	}
+
+	for _, skipped := range result.Skipped {
+		fmt.Printf("%s skipped: %s\n", skipped.ExpenseID, skipped.Reason)
+	}
+
+	if *dryRun {
+		log.Printf("%d expenses would be revalued, %d skipped", len(result.Changes), len(result.Skipped))
+		return
+	}
+	log.Printf("%d expenses revalued in run %s, %d skipped", len(result.Changes), result.RunID, len(result.Skipped))
+}
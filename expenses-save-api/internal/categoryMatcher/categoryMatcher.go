@@ -0,0 +1,146 @@
+package categorymatcher
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/google/uuid"
+)
+
+/*
+CategoryMatcher applies the category rules of a user to new expenses, like
+the ones created from card notifications.
+*/
+type CategoryMatcher struct {
+	categoryRuleRepo *repository.CategoryRuleRepository
+}
+
+func NewCategoryMatcher(categoryRuleRepo *repository.CategoryRuleRepository) *CategoryMatcher {
+	return &CategoryMatcher{categoryRuleRepo: categoryRuleRepo}
+}
+
+/*
+Applies the first rule of the user that matches the expense, changing its
+category, subcategory and description. Returns the rule that was applied,
+or nil if none matched and the expense was left as it was.
+*/
+func (m *CategoryMatcher) Apply(ctx context.Context, expense *database.Expense, vendor string) (*database.CategoryRule, error) {
+	rules, err := m.categoryRuleRepo.GetByUserID(ctx, expense.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get category rules: %w", err)
+	}
+
+	compiled := make([]*CompiledRule, 0, len(rules))
+	for i := range rules {
+		rule, err := Compile(&rules[i])
+		if err != nil {
+			// Patterns are validated when saved, a broken one should not block the others
+			continue
+		}
+		compiled = append(compiled, rule)
+	}
+
+	match := FirstMatch(compiled, InputFromExpense(expense, vendor))
+	if match == nil {
+		return nil, nil
+	}
+
+	match.Apply(expense)
+	return match.Rule, nil
+}
+
+/*
+What a rule is evaluated against. Vendor is only known for expenses created
+from card notifications; when it is empty the description is used instead,
+which is also where the vendor ends up for those expenses.
+*/
+type MatchInput struct {
+	Description     string
+	Vendor          string
+	ARSAmount       money.Money
+	PaymentMethodID uuid.UUID
+}
+
+func InputFromExpense(expense *database.Expense, vendor string) *MatchInput {
+	return &MatchInput{
+		Description:     expense.Description,
+		Vendor:          vendor,
+		ARSAmount:       expense.ARSAmount,
+		PaymentMethodID: expense.PaymentMethodID,
+	}
+}
+
+type CompiledRule struct {
+	Rule  *database.CategoryRule
+	regex *regexp.Regexp
+}
+
+func Compile(rule *database.CategoryRule) (*CompiledRule, error) {
+	compiled := &CompiledRule{Rule: rule}
+
+	if rule.MatchType == database.RuleMatchType_Regex {
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for rule %s: %w", rule.Name, err)
+		}
+		compiled.regex = regex
+	}
+
+	return compiled, nil
+}
+
+func (c *CompiledRule) Matches(input *MatchInput) bool {
+	if !c.Rule.Enabled {
+		return false
+	}
+
+	if c.Rule.PaymentMethodID != nil && *c.Rule.PaymentMethodID != input.PaymentMethodID {
+		return false
+	}
+
+	if c.Rule.MinAmount != nil && input.ARSAmount.Cmp(*c.Rule.MinAmount) < 0 {
+		return false
+	}
+
+	if c.Rule.MaxAmount != nil && input.ARSAmount.Cmp(*c.Rule.MaxAmount) > 0 {
+		return false
+	}
+
+	value := input.Description
+	if c.Rule.Field == database.RuleField_Vendor && input.Vendor != "" {
+		value = input.Vendor
+	}
+
+	if c.regex != nil {
+		return c.regex.MatchString(value)
+	}
+
+	// Substrings are case insensitive, regex can use (?i) for the same behaviour
+	return strings.Contains(strings.ToLower(value), strings.ToLower(c.Rule.Pattern))
+}
+
+// Applies the category, subcategory and description of the rule to the expense
+func (c *CompiledRule) Apply(expense *database.Expense) {
+	expense.CategoryID = c.Rule.CategoryID
+	expense.SubcategoryID = c.Rule.SubcategoryID
+
+	if c.Rule.NormalizedDescription != nil && *c.Rule.NormalizedDescription != "" {
+		expense.Description = *c.Rule.NormalizedDescription
+	}
+}
+
+// Returns the first rule that matches the input. Rules have to be sorted by priority
+func FirstMatch(rules []*CompiledRule, input *MatchInput) *CompiledRule {
+	for _, rule := range rules {
+		if rule.Matches(input) {
+			return rule
+		}
+	}
+
+	return nil
+}
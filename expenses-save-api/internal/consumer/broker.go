@@ -0,0 +1,25 @@
+package consumer
+
+import "context"
+
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+/*
+Broker is the minimum the consumer needs from a message broker. Fetch blocks
+until a message is available and does not mark it as consumed; that only
+happens once Commit is called, so a message that was fetched but not
+committed is delivered again after a restart.
+*/
+type Broker interface {
+	Fetch(ctx context.Context) (*Message, error)
+	Commit(ctx context.Context, msg *Message) error
+	Publish(ctx context.Context, topic string, msg *Message) error
+	Close() error
+}
@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type KafkaBroker struct {
+	reader *kafka.Reader
+	writer *kafka.Writer
+}
+
+func NewKafkaBroker(brokers []string, groupID string, topic string) *KafkaBroker {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		GroupID: groupID,
+		Topic:   topic,
+	})
+
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		AllowAutoTopicCreation: true,
+	}
+
+	return &KafkaBroker{reader: reader, writer: writer}
+}
+
+func (b *KafkaBroker) Fetch(ctx context.Context) (*Message, error) {
+	m, err := b.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message: %w", err)
+	}
+
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	return &Message{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+	}, nil
+}
+
+// Only the topic, partition and offset are needed to commit a message
+func (b *KafkaBroker) Commit(ctx context.Context, msg *Message) error {
+	m := kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+
+	if err := b.reader.CommitMessages(ctx, m); err != nil {
+		return fmt.Errorf("failed to commit message: %w", err)
+	}
+
+	return nil
+}
+
+func (b *KafkaBroker) Publish(ctx context.Context, topic string, msg *Message) error {
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	err := b.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish message to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+func (b *KafkaBroker) Close() error {
+	readerErr := b.reader.Close()
+	writerErr := b.writer.Close()
+
+	if readerErr != nil {
+		return fmt.Errorf("failed to close reader: %w", readerErr)
+	}
+	if writerErr != nil {
+		return fmt.Errorf("failed to close writer: %w", writerErr)
+	}
+
+	return nil
+}
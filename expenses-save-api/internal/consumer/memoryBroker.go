@@ -0,0 +1,111 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*
+In-memory Broker with a single partition per topic. Messages that are fetched
+but not committed are fetched again once the broker is rewound, the same way
+Kafka redelivers uncommitted messages after the consumer restarts.
+*/
+type MemoryBroker struct {
+	mu        sync.Mutex
+	topic     string
+	messages  map[string][]*Message
+	next      int64
+	committed int64
+	notify    chan struct{}
+}
+
+func NewMemoryBroker(topic string) *MemoryBroker {
+	return &MemoryBroker{
+		topic:    topic,
+		messages: map[string][]*Message{},
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (b *MemoryBroker) Fetch(ctx context.Context) (*Message, error) {
+	for {
+		b.mu.Lock()
+		if b.next < int64(len(b.messages[b.topic])) {
+			msg := b.messages[b.topic][b.next]
+			b.next++
+			b.mu.Unlock()
+			return msg, nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.notify:
+		}
+	}
+}
+
+func (b *MemoryBroker) Commit(ctx context.Context, msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if msg.Topic != b.topic {
+		return fmt.Errorf("message from topic %s can not be committed", msg.Topic)
+	}
+
+	if msg.Offset+1 > b.committed {
+		b.committed = msg.Offset + 1
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, msg *Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	published := *msg
+	published.Topic = topic
+	published.Partition = 0
+	published.Offset = int64(len(b.messages[topic]))
+	b.messages[topic] = append(b.messages[topic], &published)
+
+	if topic == b.topic {
+		select {
+		case b.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// Moves the read position back to the last committed offset
+func (b *MemoryBroker) Rewind() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next = b.committed
+}
+
+// Returns the messages published to a topic, like the dead-letter topic
+func (b *MemoryBroker) Messages(topic string) []*Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*Message(nil), b.messages[topic]...)
+}
+
+// Returns the offset of the next message to be consumed after a restart
+func (b *MemoryBroker) Committed() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.committed
+}
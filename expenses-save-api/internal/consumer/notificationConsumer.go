@@ -0,0 +1,281 @@
+package consumer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stdErrors "errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
+	"github.com/google/uuid"
+)
+
+const (
+	NotificationTopic = "notification.new"
+	DeadLetterTopic   = "notification.new.dlq"
+
+	baseBackoff = time.Second
+	maxBackoff  = time.Minute
+)
+
+// Message published by expenses-receiver for every payment notification
+type NewExpenseMessage struct {
+	UserID           string `json:"userId"`
+	NotificationInfo struct {
+		App            string      `json:"app"`
+		Vendor         string      `json:"vendor"`
+		PaymentMethod  string      `json:"paymentMethod"`
+		Amount         money.Money `json:"amount"`
+		StrTimestamptz string      `json:"strTimestamptz"`
+	} `json:"notificationInfo"`
+}
+
+// A message that will never be processed successfully, no matter how many times it is retried
+type poisonError struct {
+	err error
+}
+
+func (e *poisonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *poisonError) Unwrap() error {
+	return e.err
+}
+
+/*
+NotificationConsumer turns the notification.new messages into expenses using
+the same validation and insert flow as the gRPC AddExpense. An offset is only
+committed once its expense is stored or the message was sent to the
+dead-letter topic, so transient failures (e.g. the database being down) are
+retried until they succeed instead of losing the message.
+
+Notifications do not carry a category, so the category rules of the user are
+applied to every expense created from them. Expenses that no rule matches are
+assigned to defaultCategory, or sent to the dead-letter topic if it is empty.
+*/
+type NotificationConsumer struct {
+	broker                  Broker
+	expenseValidatorService *validator.ExpenseValidatorService
+	expenseService          *expense.ExpenseService
+	userRepo                *repository.UserRepository
+	userPreferenceRepo      *repository.UserPreferenceRepository
+	defaultCategory         string
+	// Stores the expense of a message, handleNotification unless replaced in tests
+	handle func(ctx context.Context, msg *Message) error
+}
+
+func NewNotificationConsumer(
+	broker Broker,
+	expenseValidatorService *validator.ExpenseValidatorService,
+	expenseService *expense.ExpenseService,
+	userRepo *repository.UserRepository,
+	userPreferenceRepo *repository.UserPreferenceRepository,
+	defaultCategory string,
+) *NotificationConsumer {
+	c := &NotificationConsumer{
+		broker:                  broker,
+		expenseValidatorService: expenseValidatorService,
+		expenseService:          expenseService,
+		userRepo:                userRepo,
+		userPreferenceRepo:      userPreferenceRepo,
+		defaultCategory:         defaultCategory,
+	}
+	c.handle = c.handleNotification
+
+	return c
+}
+
+// Consumes messages until the context is cancelled
+func (c *NotificationConsumer) Start(ctx context.Context) {
+	defer c.broker.Close()
+
+	for {
+		msg, err := c.broker.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to fetch notification: %v", err)
+			if !sleep(ctx, baseBackoff) {
+				return
+			}
+			continue
+		}
+
+		c.consume(ctx, msg)
+	}
+}
+
+// Retries the message until it is committed or the context is cancelled
+func (c *NotificationConsumer) consume(ctx context.Context, msg *Message) {
+	for attempts := 1; ; attempts++ {
+		err := c.process(ctx, msg)
+		if err == nil {
+			return
+		}
+
+		log.Printf("failed to process notification %s/%d/%d (attempt %d): %v", msg.Topic, msg.Partition, msg.Offset, attempts, err)
+
+		if !sleep(ctx, backoff(attempts)) {
+			return
+		}
+	}
+}
+
+func (c *NotificationConsumer) process(ctx context.Context, msg *Message) error {
+	err := c.handle(ctx, msg)
+
+	var poisonErr *poisonError
+	if stdErrors.As(err, &poisonErr) {
+		log.Printf("sending notification %s/%d/%d to %s: %v", msg.Topic, msg.Partition, msg.Offset, DeadLetterTopic, err)
+		err = c.deadLetter(ctx, msg, poisonErr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.broker.Commit(ctx, msg)
+}
+
+func (c *NotificationConsumer) handleNotification(ctx context.Context, msg *Message) error {
+	var notification NewExpenseMessage
+	if err := json.Unmarshal(msg.Value, &notification); err != nil {
+		return &poisonError{fmt.Errorf("failed to unmarshal notification: %w", err)}
+	}
+
+	userID, err := uuid.Parse(notification.UserID)
+	if err != nil {
+		return &poisonError{fmt.Errorf("invalid user ID %s: %w", notification.UserID, err)}
+	}
+
+	exists, err := c.userRepo.Exists(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return &poisonError{fmt.Errorf("user %s does not exist", userID)}
+	}
+
+	preference, err := c.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	req, err := c.toRequest(&notification, preference.Timezone)
+	if err != nil {
+		return &poisonError{err}
+	}
+
+	expense, err := c.expenseValidatorService.GetExpenseFromRequestWithRules(ctx, userID, req, notification.NotificationInfo.Vendor)
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return &poisonError{err}
+		}
+		return fmt.Errorf("failed to get expense from request: %w", err)
+	}
+
+	expense.IdempotencyKey = &req.IdempotencyKey
+
+	if _, err := c.expenseService.InsertExpense(ctx, expense); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+/*
+The idempotency key is derived from the content of the notification, so a
+message that is redelivered because its offset was not committed (e.g. the
+service stopped right after inserting the expense) or that is published twice
+by expenses-receiver does not create a duplicate.
+
+Notifications do not say where the purchase happened, the offset of
+strTimestamptz is usually UTC. The expense is stored in timezone, the one of
+the user, so its calendar day is the one the user saw.
+*/
+func (c *NotificationConsumer) toRequest(notification *NewExpenseMessage, timezone string) (*proto.NewExpenseRequest, error) {
+	info := notification.NotificationInfo
+
+	if _, err := time.Parse(time.RFC3339, info.StrTimestamptz); err != nil {
+		return nil, fmt.Errorf("invalid strTimestamptz %s: %w", info.StrTimestamptz, err)
+	}
+
+	return &proto.NewExpenseRequest{
+		UserId: notification.UserID,
+		ExpenseInfo: &proto.ExpenseInfo{
+			Name:              info.Vendor,
+			Currency:          "ARS",
+			Amount:            info.Amount.Float64(),
+			AmountDecimal:     info.Amount.String(),
+			CategoryName:      c.defaultCategory,
+			PaymentMethodName: info.PaymentMethod,
+			Date:              info.StrTimestamptz,
+			Timezone:          timezone,
+		},
+		IdempotencyKey: idempotencyKey(notification),
+	}, nil
+}
+
+func idempotencyKey(notification *NewExpenseMessage) string {
+	info := notification.NotificationInfo
+	// Separated so that e.g. the vendor cannot be confused with the end of the amount
+	content := fmt.Sprintf("%s\x00%s\x00%s\x00%s", notification.UserID, info.StrTimestamptz, info.Amount, info.Vendor)
+	hash := sha256.Sum256([]byte(content))
+
+	return "kafka:" + hex.EncodeToString(hash[:])
+}
+
+func (c *NotificationConsumer) deadLetter(ctx context.Context, msg *Message, cause error) error {
+	headers := make(map[string]string, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["error"] = cause.Error()
+	headers["original-topic"] = msg.Topic
+	headers["original-partition"] = strconv.Itoa(msg.Partition)
+	headers["original-offset"] = strconv.FormatInt(msg.Offset, 10)
+
+	dlqMsg := &Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+
+	if err := c.broker.Publish(ctx, DeadLetterTopic, dlqMsg); err != nil {
+		return fmt.Errorf("failed to send notification to dead-letter topic: %w", err)
+	}
+
+	return nil
+}
+
+// Exponential backoff starting at baseBackoff and capped at maxBackoff
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
+
+// Returns false if the context was cancelled before the duration elapsed
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
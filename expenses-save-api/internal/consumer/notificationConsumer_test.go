@@ -0,0 +1,163 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+)
+
+const testNotification = `{
+	"userId": "7f0c1a52-2a4e-4a51-9f5e-0b0f3c1d2e3f",
+	"notificationInfo": {
+		"app": "mercadopago",
+		"vendor": "Coto",
+		"paymentMethod": "Mercado Pago",
+		"amount": 1234.5,
+		"strTimestamptz": "2026-10-17T12:30:00Z"
+	}
+}`
+
+// Publishes value to the notification topic and fetches it back
+func fetchNotification(t *testing.T, broker *MemoryBroker, value string) *Message {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := broker.Publish(ctx, NotificationTopic, &Message{Key: []byte("key"), Value: []byte(value)}); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	msg, err := broker.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("failed to fetch: %v", err)
+	}
+
+	return msg
+}
+
+// Consumer without database dependencies whose expenses are stored by handle
+func newTestConsumer(broker *MemoryBroker, handle func(ctx context.Context, msg *Message) error) *NotificationConsumer {
+	c := NewNotificationConsumer(broker, nil, nil, nil, nil, "")
+	if handle != nil {
+		c.handle = handle
+	}
+	return c
+}
+
+func TestProcessCommitsAfterInsert(t *testing.T) {
+	broker := NewMemoryBroker(NotificationTopic)
+	inserted := 0
+	c := newTestConsumer(broker, func(ctx context.Context, msg *Message) error {
+		if broker.Committed() != 0 {
+			t.Errorf("offset committed before the expense was inserted")
+		}
+		inserted++
+		return nil
+	})
+
+	msg := fetchNotification(t, broker, testNotification)
+	if err := c.process(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if inserted != 1 {
+		t.Errorf("inserted %d expenses, want 1", inserted)
+	}
+	if broker.Committed() != 1 {
+		t.Errorf("committed offset = %d, want 1", broker.Committed())
+	}
+	if dlq := broker.Messages(DeadLetterTopic); len(dlq) != 0 {
+		t.Errorf("%d messages sent to the dead-letter topic, want 0", len(dlq))
+	}
+}
+
+func TestProcessTransientError(t *testing.T) {
+	broker := NewMemoryBroker(NotificationTopic)
+	attempts := 0
+	c := newTestConsumer(broker, func(ctx context.Context, msg *Message) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	})
+
+	msg := fetchNotification(t, broker, testNotification)
+	if err := c.process(context.Background(), msg); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	if broker.Committed() != 0 {
+		t.Errorf("committed offset = %d, want 0", broker.Committed())
+	}
+	if dlq := broker.Messages(DeadLetterTopic); len(dlq) != 0 {
+		t.Fatalf("%d messages sent to the dead-letter topic, want 0", len(dlq))
+	}
+
+	// As after a restart, the uncommitted message is delivered again
+	broker.Rewind()
+	redelivered, err := broker.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch: %v", err)
+	}
+	if redelivered.Offset != msg.Offset {
+		t.Fatalf("redelivered offset = %d, want %d", redelivered.Offset, msg.Offset)
+	}
+
+	if err := c.process(context.Background(), redelivered); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if broker.Committed() != 1 {
+		t.Errorf("committed offset = %d, want 1", broker.Committed())
+	}
+}
+
+func TestProcessPoisonMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		handle func(ctx context.Context, msg *Message) error
+	}{
+		{name: "invalid JSON", value: `not json`},
+		{name: "invalid user ID", value: `{"userId": "nope", "notificationInfo": {"strTimestamptz": "2026-10-17T12:30:00Z"}}`},
+		{
+			name:  "validation error",
+			value: testNotification,
+			handle: func(ctx context.Context, msg *Message) error {
+				return &poisonError{&errors.ValidationError{Field: "paymentMethod", Message: "payment method not found", Code: int32(errors.InvalidPaymentMethod)}}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewMemoryBroker(NotificationTopic)
+			c := newTestConsumer(broker, tt.handle)
+
+			msg := fetchNotification(t, broker, tt.value)
+			if err := c.process(context.Background(), msg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			dlq := broker.Messages(DeadLetterTopic)
+			if len(dlq) != 1 {
+				t.Fatalf("%d messages sent to the dead-letter topic, want 1", len(dlq))
+			}
+			if string(dlq[0].Value) != tt.value {
+				t.Errorf("dead-letter value = %q, want %q", dlq[0].Value, tt.value)
+			}
+			if dlq[0].Headers["error"] == "" {
+				t.Errorf("dead-letter message has no error header")
+			}
+			if dlq[0].Headers["original-offset"] != "0" {
+				t.Errorf("original-offset = %q, want 0", dlq[0].Headers["original-offset"])
+			}
+
+			// Committed so the message is not consumed again
+			if broker.Committed() != 1 {
+				t.Errorf("committed offset = %d, want 1", broker.Committed())
+			}
+		})
+	}
+}
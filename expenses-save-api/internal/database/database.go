@@ -3,12 +3,14 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -60,6 +62,19 @@ func (s *DatabaseService) BeginTx(ctx context.Context) (pgx.Tx, error) {
 	return s.pool.Begin(ctx)
 }
 
+// Postgres error code for unique_violation
+const uniqueViolationCode = "23505"
+
+// Reports whether err was caused by a row violating the given unique constraint or index
+func IsUniqueViolation(err error, constraintName string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == constraintName
+}
+
 // ============================================================================
 // Legacy Methods (kept for backward compatibility)
 // ============================================================================
@@ -91,136 +106,6 @@ func (s *DatabaseService) GetDestinationsByUserId(userID uuid.UUID) ([]*UserExpe
 	return expenses, nil
 }
 
-func (s *DatabaseService) GetCategoryByName(userID uuid.UUID, categoryName string) (*Category, error) {
-	var category Category
-
-	err := s.pool.QueryRow(
-		context.Background(),
-		"SELECT id, user_id, name FROM public.category WHERE user_id = $1 AND LOWER(name) = LOWER($2) LIMIT 1",
-		userID,
-		categoryName,
-	).Scan(&category.Id, &category.UserID, &category.Name)
-
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	return &category, nil
-}
-
-func (s *DatabaseService) GetSubcategoryByName(categoryID uuid.UUID, subcategoryName string) (*Subcategory, error) {
-	var subcategory Subcategory
-
-	err := s.pool.QueryRow(
-		context.Background(),
-		"SELECT id, category_id, name FROM public.subcategory WHERE category_id = $1 AND LOWER(name) = LOWER($2) LIMIT 1",
-		categoryID,
-		subcategoryName,
-	).Scan(&subcategory.Id, &subcategory.CategoryID, &subcategory.Name)
-
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	return &subcategory, nil
-}
-
-func (s *DatabaseService) GetPaymentMethodByName(userID uuid.UUID, paymentMethodName string) (*PaymentMethod, error) {
-	var paymentMethod PaymentMethod
-
-	err := s.pool.QueryRow(
-		context.Background(),
-		"SELECT id, user_id, name FROM public.payment_method WHERE user_id = $1 AND LOWER(name) = LOWER($2) LIMIT 1",
-		userID,
-		paymentMethodName,
-	).Scan(&paymentMethod.Id, &paymentMethod.UserID, &paymentMethod.Name)
-
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	return &paymentMethod, nil
-}
-
-// Deprecated: Use ExpenseRepository.Insert instead
-func (s *DatabaseService) InsertExpense(expense *Expense) (uuid.UUID, error) {
-	var id uuid.UUID
-
-	err := s.pool.QueryRow(context.Background(), `
-		INSERT INTO public.expense (
-			user_id,
-			description,
-			payment_method_id,
-			ars_amount,
-			usd_amount,
-			category_id,
-			subcategory_id,
-			date
-		) VALUES
-		($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id
-	`,
-		expense.UserID,
-		expense.Description,
-		expense.PaymentMethodID,
-		expense.ARSAmount,
-		expense.USDAmount,
-		expense.CategoryID,
-		expense.SubcategoryID,
-		expense.Date,
-	).Scan(&id)
-
-	return id, err
-}
-
-func (s *DatabaseService) RetrieveExpenseForSheets(expenseID uuid.UUID) (*ExpenseSheetsRow, error) {
-	var expense ExpenseSheetsRow
-
-	err := s.pool.QueryRow(context.Background(), `
-		SELECT
-			e.id,
-			e.date,
-			e.description,
-			pm.name AS payment_method_name,
-			e.ars_amount,
-			e.usd_amount,
-			c.name AS category_name,
-			sc.name AS subcategory_name,
-			e.created_date
-		FROM expense e
-		JOIN payment_method pm ON pm.id = e.payment_method_id
-		JOIN category c ON c.id = e.category_id
-		LEFT JOIN subcategory sc ON sc.id = e.subcategory_id
-		WHERE e.id = $1
-		LIMIT 1;
-	`, expenseID).Scan(
-		&expense.ID,
-		&expense.Date,
-		&expense.Description,
-		&expense.PaymentMethodName,
-		&expense.ARSAmount,
-		&expense.USDAmount,
-		&expense.CategoryName,
-		&expense.SubcategoryName,
-		&expense.CreatedDate,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &expense, nil
-}
-
 func collectRow(row pgx.CollectableRow) (*UserExpenseSave, error) {
 	var (
 		u       UserExpenseSave
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const categoryRuleColumns = `id, user_id, name, priority, field, match_type, pattern, min_amount, max_amount,
+	payment_method_id, category_id, subcategory_id, normalized_description, enabled, created_date`
+
+type CategoryRuleRepository struct {
+	db *database.DatabaseService
+}
+
+func NewCategoryRuleRepository(db *database.DatabaseService) *CategoryRuleRepository {
+	return &CategoryRuleRepository{db: db}
+}
+
+// Rules are returned in evaluation order
+func (r *CategoryRuleRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]database.CategoryRule, error) {
+	rows, err := r.db.Query(
+		ctx,
+		"SELECT "+categoryRuleColumns+" FROM public.category_rule WHERE user_id = $1 ORDER BY priority DESC, created_date ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.CategoryRule])
+}
+
+func (r *CategoryRuleRepository) GetByID(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*database.CategoryRule, error) {
+	rows, err := r.db.Query(
+		ctx,
+		"SELECT "+categoryRuleColumns+" FROM public.category_rule WHERE id = $1 AND user_id = $2",
+		id,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[database.CategoryRule])
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (r *CategoryRuleRepository) Insert(ctx context.Context, rule *database.CategoryRule) (*database.CategoryRule, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`INSERT INTO public.category_rule (
+			user_id, name, priority, field, match_type, pattern, min_amount, max_amount,
+			payment_method_id, category_id, subcategory_id, normalized_description, enabled
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+categoryRuleColumns,
+		rule.UserID,
+		rule.Name,
+		rule.Priority,
+		rule.Field,
+		rule.MatchType,
+		rule.Pattern,
+		rule.MinAmount,
+		rule.MaxAmount,
+		rule.PaymentMethodID,
+		rule.CategoryID,
+		rule.SubcategoryID,
+		rule.NormalizedDescription,
+		rule.Enabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[database.CategoryRule])
+	if err != nil {
+		return nil, err
+	}
+
+	return &inserted, nil
+}
+
+// Returns pgx.ErrNoRows if the rule does not exist for the user
+func (r *CategoryRuleRepository) Update(ctx context.Context, rule *database.CategoryRule) (*database.CategoryRule, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`UPDATE public.category_rule SET
+			name = $3,
+			priority = $4,
+			field = $5,
+			match_type = $6,
+			pattern = $7,
+			min_amount = $8,
+			max_amount = $9,
+			payment_method_id = $10,
+			category_id = $11,
+			subcategory_id = $12,
+			normalized_description = $13,
+			enabled = $14
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+categoryRuleColumns,
+		rule.ID,
+		rule.UserID,
+		rule.Name,
+		rule.Priority,
+		rule.Field,
+		rule.MatchType,
+		rule.Pattern,
+		rule.MinAmount,
+		rule.MaxAmount,
+		rule.PaymentMethodID,
+		rule.CategoryID,
+		rule.SubcategoryID,
+		rule.NormalizedDescription,
+		rule.Enabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[database.CategoryRule])
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// Returns pgx.ErrNoRows if the rule does not exist for the user
+func (r *CategoryRuleRepository) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	var deletedID uuid.UUID
+
+	return r.db.QueryRow(
+		ctx,
+		"DELETE FROM public.category_rule WHERE id = $1 AND user_id = $2 RETURNING id",
+		id,
+		userID,
+	).Scan(&deletedID)
+}
+
+// Checks that the category, subcategory (optional) and payment method (optional) of a rule belong to the user
+func (r *CategoryRuleRepository) CheckReferences(ctx context.Context, userID uuid.UUID, categoryID uuid.UUID, subcategoryID *uuid.UUID, paymentMethodID *uuid.UUID) (categoryOk bool, subcategoryOk bool, paymentMethodOk bool, err error) {
+	err = r.db.QueryRow(
+		ctx,
+		`SELECT
+			EXISTS (SELECT 1 FROM public.category WHERE id = $2 AND user_id = $1),
+			$3::uuid IS NULL OR EXISTS (SELECT 1 FROM public.subcategory WHERE id = $3 AND category_id = $2),
+			$4::uuid IS NULL OR EXISTS (SELECT 1 FROM public.payment_method WHERE id = $4 AND user_id = $1)`,
+		userID,
+		categoryID,
+		subcategoryID,
+		paymentMethodID,
+	).Scan(&categoryOk, &subcategoryOk, &paymentMethodOk)
+
+	return categoryOk, subcategoryOk, paymentMethodOk, err
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+type CurrencyRateRepository struct {
+	db *database.DatabaseService
+}
+
+func NewCurrencyRateRepository(db *database.DatabaseService) *CurrencyRateRepository {
+	return &CurrencyRateRepository{db: db}
+}
+
+// Replaces the rate of the day if there is one
+func (r *CurrencyRateRepository) Upsert(ctx context.Context, rate *database.CurrencyRate) error {
+	return r.db.Exec(
+		ctx,
+		`INSERT INTO public.currency_rate (currency, date, rate, fetched_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (currency, date) DO UPDATE SET
+			rate = EXCLUDED.rate,
+			fetched_at = EXCLUDED.fetched_at`,
+		rate.Currency,
+		rate.Date,
+		rate.Rate,
+		rate.FetchedAt,
+	)
+}
+
+/*
+Returns the rate of the day or, if the day is missing, the closest previous
+one. Returns pgx.ErrNoRows if there is no rate of the currency on or before
+the day.
+*/
+func (r *CurrencyRateRepository) GetAtDate(ctx context.Context, currency string, date time.Time) (*database.CurrencyRate, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT currency, date, rate, fetched_at
+		FROM public.currency_rate
+		WHERE currency = $1 AND date <= $2
+		ORDER BY date DESC
+		LIMIT 1`,
+		currency,
+		date,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[database.CurrencyRate])
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+type CurrencyRepository struct {
+	db *database.DatabaseService
+}
+
+func NewCurrencyRepository(db *database.DatabaseService) *CurrencyRepository {
+	return &CurrencyRepository{db: db}
+}
+
+func (r *CurrencyRepository) GetAll(ctx context.Context) ([]database.Currency, error) {
+	rows, err := r.db.Query(ctx, "SELECT code, name FROM public.currency ORDER BY code ASC")
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.Currency])
+}
+
+func (r *CurrencyRepository) Exists(ctx context.Context, code string) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM public.currency WHERE code = $1)",
+		code,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
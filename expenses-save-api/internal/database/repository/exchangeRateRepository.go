@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExchangeRateRepository struct {
+	db *database.DatabaseService
+}
+
+func NewExchangeRateRepository(db *database.DatabaseService) *ExchangeRateRepository {
+	return &ExchangeRateRepository{db: db}
+}
+
+// Replaces the rate of the day if there is one
+func (r *ExchangeRateRepository) Upsert(ctx context.Context, rate *database.ExchangeRate) error {
+	return r.db.Exec(
+		ctx,
+		`INSERT INTO public.exchange_rate (rate_type, date, rate, provider, components, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (rate_type, date) DO UPDATE SET
+			rate = EXCLUDED.rate,
+			provider = EXCLUDED.provider,
+			components = EXCLUDED.components,
+			fetched_at = EXCLUDED.fetched_at`,
+		rate.RateType,
+		rate.Date,
+		rate.Rate,
+		rate.Provider,
+		rate.Components,
+		rate.FetchedAt,
+	)
+}
+
+/*
+Returns the rate of the day or, if the day is missing (e.g. weekends and
+holidays), the closest previous one. Returns pgx.ErrNoRows if there are no
+rates of the type on or before the day.
+*/
+func (r *ExchangeRateRepository) GetAtDate(ctx context.Context, rateType database.RateType, date time.Time) (*database.ExchangeRate, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT rate_type, date, rate, provider, components, fetched_at
+		FROM public.exchange_rate
+		WHERE rate_type = $1 AND date <= $2
+		ORDER BY date DESC
+		LIMIT 1`,
+		rateType,
+		date,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[database.ExchangeRate])
+}
+
+// Last stored rate of the type. Returns pgx.ErrNoRows if there is none
+func (r *ExchangeRateRepository) GetLatest(ctx context.Context, rateType database.RateType) (*database.ExchangeRate, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT rate_type, date, rate, provider, components, fetched_at
+		FROM public.exchange_rate
+		WHERE rate_type = $1
+		ORDER BY date DESC
+		LIMIT 1`,
+		rateType,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[database.ExchangeRate])
+}
+
+/*
+Stored rates between the days, both included, ordered by date and rate type.
+rateType is optional, all the types are returned without it.
+*/
+func (r *ExchangeRateRepository) GetRange(ctx context.Context, rateType *database.RateType, from time.Time, to time.Time) ([]database.ExchangeRate, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT rate_type, date, rate, provider, components, fetched_at
+		FROM public.exchange_rate
+		WHERE date BETWEEN $1 AND $2
+			AND ($3::text IS NULL OR rate_type = $3)
+		ORDER BY date ASC, rate_type ASC`,
+		from,
+		to,
+		rateType,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.ExchangeRate])
+}
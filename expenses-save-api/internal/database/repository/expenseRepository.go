@@ -6,10 +6,14 @@ import (
 	"time"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
 
+// Unique index over (user_id, idempotency_key)
+const expenseIdempotencyKeyIndex = "expense_user_idempotency_key_idx"
+
 type ExpenseRepository struct {
 	db *database.DatabaseService
 }
@@ -25,30 +29,35 @@ func (r *ExpenseRepository) GetByUserIDAndDateRange(ctx context.Context, userID
 			description, 
 			payment_method_id,
 			ars_amount,
-			CASE 
-				WHEN usd_amount = 'NaN' THEN 0 
-				ELSE usd_amount
-			END as usd_amount,
+			usd_amount,
+			currency,
+			original_amount,
+			COALESCE(usd_ars_rate, 0) AS usd_ars_rate,
+			COALESCE(currency_usd_rate, 0) AS currency_usd_rate,
+			rate_type,
+			rate_provider,
+			rate_fetched_at,
 			category_id, 
 			subcategory_id,
 			recurrent_expense_id,
 			installements_expense_id,
-			date 
+			date,
+			timezone,
+			idempotency_key
 		FROM public.expense 
 		WHERE user_id = $1`
 
 	args := []any{userID}
 
+	// Days are compared in the timezone of each expense, see localDate
 	if startDate != nil {
-		startOfDay := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, startDate.Location())
-		args = append(args, startOfDay)
-		query += fmt.Sprintf(" AND date >= $%d", len(args))
+		args = append(args, calendarDay(*startDate))
+		query += fmt.Sprintf(" AND local_date >= $%d", len(args))
 	}
 
 	if endDate != nil {
-		endOfDay := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 23, 59, 59, 999999999, endDate.Location())
-		args = append(args, endOfDay)
-		query += fmt.Sprintf(" AND date <= $%d", len(args))
+		args = append(args, calendarDay(*endDate))
+		query += fmt.Sprintf(" AND local_date <= $%d", len(args))
 	}
 
 	if categoryID != nil {
@@ -61,7 +70,7 @@ func (r *ExpenseRepository) GetByUserIDAndDateRange(ctx context.Context, userID
 		query += fmt.Sprintf(" AND subcategory_id = $%d", len(args))
 	}
 
-	query += " ORDER BY date DESC"
+	query += " ORDER BY date DESC, created_date DESC"
 
 	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
@@ -76,7 +85,80 @@ func (r *ExpenseRepository) GetByUserIDAndDateRange(ctx context.Context, userID
 	return expenses, nil
 }
 
-func (r *ExpenseRepository) InsertFromStrings(ctx context.Context, userID uuid.UUID, description string, paymentMethodID string, arsAmount float64, usdAmount float64, categoryID string, subcategoryID *string, recurrentExpenseID *string, date time.Time) (*database.Expense, error) {
+func (r *ExpenseRepository) GetSheetsRowsByUserID(ctx context.Context, userID uuid.UUID) ([]database.ExpenseSheetsRow, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			e.id,
+			e.date,
+			e.timezone,
+			e.description,
+			pm.name AS payment_method_name,
+			e.ars_amount,
+			e.usd_amount,
+			e.currency,
+			e.original_amount,
+			c.name AS category_name,
+			sc.name AS subcategory_name,
+			e.created_date,
+			COALESCE(up.date_format, 'YYYY-MM-DD') AS date_format
+		FROM expense e
+		JOIN payment_method pm ON pm.id = e.payment_method_id
+		JOIN category c ON c.id = e.category_id
+		LEFT JOIN subcategory sc ON sc.id = e.subcategory_id
+		LEFT JOIN user_preference up ON up.user_id = e.user_id
+		WHERE e.user_id = $1
+		ORDER BY e.date ASC, e.created_date ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, err := pgx.CollectRows(rows, pgx.RowToStructByName[database.ExpenseSheetsRow])
+	if err != nil {
+		return nil, err
+	}
+
+	return expenses, nil
+}
+
+// Expenses that do not exist (e.g. deleted) are left out
+func (r *ExpenseRepository) GetSheetsRowsByIDs(ctx context.Context, expenseIDs []uuid.UUID) ([]database.ExpenseSheetsRow, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			e.id,
+			e.date,
+			e.timezone,
+			e.description,
+			pm.name AS payment_method_name,
+			e.ars_amount,
+			e.usd_amount,
+			e.currency,
+			e.original_amount,
+			c.name AS category_name,
+			sc.name AS subcategory_name,
+			e.created_date,
+			COALESCE(up.date_format, 'YYYY-MM-DD') AS date_format
+		FROM expense e
+		JOIN payment_method pm ON pm.id = e.payment_method_id
+		JOIN category c ON c.id = e.category_id
+		LEFT JOIN subcategory sc ON sc.id = e.subcategory_id
+		LEFT JOIN user_preference up ON up.user_id = e.user_id
+		WHERE e.id = ANY($1)
+		ORDER BY e.date ASC, e.created_date ASC
+	`, expenseIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, err := pgx.CollectRows(rows, pgx.RowToStructByName[database.ExpenseSheetsRow])
+	if err != nil {
+		return nil, err
+	}
+
+	return expenses, nil
+}
+
+func (r *ExpenseRepository) InsertFromStringsWithTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, description string, paymentMethodID string, amount *database.ExpenseAmount, categoryID string, subcategoryID *string, recurrentExpenseID *string, date time.Time, timezone string, idempotencyKey *string) (*database.Expense, error) {
 	paymentMethodUUID := uuid.MustParse(paymentMethodID)
 	categoryUUID := uuid.MustParse(categoryID)
 
@@ -93,18 +175,20 @@ func (r *ExpenseRepository) InsertFromStrings(ctx context.Context, userID uuid.U
 	}
 
 	expense := &database.Expense{
-		UserID:          userID,
-		Description:     description,
-		PaymentMethodID: paymentMethodUUID,
-		ARSAmount:       arsAmount,
-		USDAmount:       usdAmount,
-		CategoryID:      categoryUUID,
-		SubcategoryID:   subcategoryUUID,
-		Date:            date,
+		UserID:             userID,
+		Description:        description,
+		PaymentMethodID:    paymentMethodUUID,
+		CategoryID:         categoryUUID,
+		SubcategoryID:      subcategoryUUID,
+		RecurrentExpenseID: recurrentExpenseUUID,
+		Date:               date,
+		Timezone:           timezone,
+		IdempotencyKey:     idempotencyKey,
 	}
+	expense.SetAmount(amount)
 
 	// Insert and get ID
-	id, err := r.Insert(ctx, expense, recurrentExpenseUUID)
+	id, err := r.InsertWithTx(ctx, tx, expense, recurrentExpenseUUID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -113,38 +197,6 @@ func (r *ExpenseRepository) InsertFromStrings(ctx context.Context, userID uuid.U
 	return expense, nil
 }
 
-func (r *ExpenseRepository) Insert(ctx context.Context, expense *database.Expense, recurrentExpenseID *uuid.UUID) (uuid.UUID, error) {
-	var id uuid.UUID
-
-	err := r.db.QueryRow(ctx, `
-		INSERT INTO public.expense (
-			user_id,
-			description,
-			payment_method_id,
-			ars_amount,
-			usd_amount,
-			category_id,
-			subcategory_id,
-			recurrent_expense_id,
-			date
-		) VALUES
-		($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id
-	`,
-		expense.UserID,
-		expense.Description,
-		expense.PaymentMethodID,
-		expense.ARSAmount,
-		expense.USDAmount,
-		expense.CategoryID,
-		expense.SubcategoryID,
-		recurrentExpenseID,
-		expense.Date,
-	).Scan(&id)
-
-	return id, err
-}
-
 func (r *ExpenseRepository) GetByID(ctx context.Context, expenseID uuid.UUID, userID uuid.UUID) (*database.Expense, error) {
 	row, err := r.db.Query(
 		ctx,
@@ -154,15 +206,21 @@ func (r *ExpenseRepository) GetByID(ctx context.Context, expenseID uuid.UUID, us
 			description, 
 			payment_method_id,
 			ars_amount,
-			CASE 
-				WHEN usd_amount = 'NaN' THEN 0 
-				ELSE usd_amount
-			END as usd_amount,
+			usd_amount,
+			currency,
+			original_amount,
+			COALESCE(usd_ars_rate, 0) AS usd_ars_rate,
+			COALESCE(currency_usd_rate, 0) AS currency_usd_rate,
+			rate_type,
+			rate_provider,
+			rate_fetched_at,
 			category_id, 
 			subcategory_id,
 			recurrent_expense_id,
 			installements_expense_id,
-			date 
+			date,
+			timezone,
+			idempotency_key
 		FROM public.expense 
 		WHERE id = $1 AND user_id = $2`,
 		expenseID,
@@ -184,7 +242,90 @@ func (r *ExpenseRepository) GetByID(ctx context.Context, expenseID uuid.UUID, us
 	return &expenses[0], nil
 }
 
-func (r *ExpenseRepository) Update(ctx context.Context, expenseID uuid.UUID, userID uuid.UUID, description string, paymentMethodID string, arsAmount float64, usdAmount float64, categoryID string, subcategoryID *string, recurrentExpenseID *string, date time.Time) (*database.Expense, error) {
+// Reports whether an insert failed because another expense of the user already has the idempotency key
+func IsIdempotencyKeyConflict(err error) bool {
+	return database.IsUniqueViolation(err, expenseIdempotencyKeyIndex)
+}
+
+// Returns pgx.ErrNoRows if the user has no expense created with the key
+func (r *ExpenseRepository) GetByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey string) (*database.Expense, error) {
+	var id uuid.UUID
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT id FROM public.expense WHERE user_id = $1 AND idempotency_key = $2",
+		userID,
+		idempotencyKey,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetByID(ctx, id, userID)
+}
+
+// Returns the IDs of the expenses of the user created with any of the keys, by key
+/*
+IDs of the expenses created with each key. The key is only stored in the first
+installment of an installment purchase, the rest of them are looked up by
+installment expense so all are returned, by date.
+*/
+func (r *ExpenseRepository) GetIDsByIdempotencyKeys(ctx context.Context, userID uuid.UUID, idempotencyKeys []string) (map[string][]uuid.UUID, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT k.idempotency_key, e.id
+		FROM public.expense k
+		JOIN public.expense e ON e.id = k.id
+			OR (e.installements_expense_id = k.installements_expense_id AND e.user_id = k.user_id)
+		WHERE k.user_id = $1 AND k.idempotency_key = ANY($2)
+		ORDER BY e.date ASC`,
+		userID,
+		idempotencyKeys,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string][]uuid.UUID)
+	for rows.Next() {
+		var (
+			key string
+			id  uuid.UUID
+		)
+		if err := rows.Scan(&key, &id); err != nil {
+			return nil, err
+		}
+		ids[key] = append(ids[key], id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *ExpenseRepository) GetIDsByInstallmentExpenseID(ctx context.Context, installmentExpenseID uuid.UUID, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := r.db.Query(
+		ctx,
+		"SELECT id FROM public.expense WHERE installements_expense_id = $1 AND user_id = $2 ORDER BY date ASC",
+		installmentExpenseID,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *ExpenseRepository) UpdateWithTx(ctx context.Context, tx pgx.Tx, expenseID uuid.UUID, userID uuid.UUID, description string, paymentMethodID string, amount *database.ExpenseAmount, categoryID string, subcategoryID *string, recurrentExpenseID *string, date time.Time, timezone string) error {
 	paymentMethodUUID := uuid.MustParse(paymentMethodID)
 	categoryUUID := uuid.MustParse(categoryID)
 
@@ -200,46 +341,62 @@ func (r *ExpenseRepository) Update(ctx context.Context, expenseID uuid.UUID, use
 		recurrentExpenseUUID = &parsed
 	}
 
-	err := r.db.Exec(ctx, `
+	tag, err := tx.Exec(ctx, `
 		UPDATE public.expense 
 		SET 
 			description = $1,
 			payment_method_id = $2,
 			ars_amount = $3,
 			usd_amount = $4,
-			category_id = $5,
-			subcategory_id = $6,
-			recurrent_expense_id = $7,
-			date = $8
-		WHERE id = $9 AND user_id = $10
+			currency = $5,
+			original_amount = $6,
+			usd_ars_rate = $7,
+			currency_usd_rate = $8,
+			rate_type = $9,
+			rate_provider = $10,
+			rate_fetched_at = $11,
+			category_id = $12,
+			subcategory_id = $13,
+			recurrent_expense_id = $14,
+			date = $15,
+			timezone = $16,
+			local_date = $17
+		WHERE id = $18 AND user_id = $19
 	`,
 		description,
 		paymentMethodUUID,
-		arsAmount,
-		usdAmount,
+		amount.ARSAmount,
+		amount.USDAmount,
+		amount.Currency,
+		amount.OriginalAmount,
+		knownRate(amount.UsdArsRate),
+		knownRate(amount.CurrencyUsdRate),
+		amount.RateType,
+		amount.RateProvider,
+		amount.RateFetchedAt,
 		categoryUUID,
 		subcategoryUUID,
 		recurrentExpenseUUID,
 		date,
+		timezone,
+		localDate(date, timezone),
 		expenseID,
 		userID,
 	)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return r.GetByID(ctx, expenseID, userID)
-}
-
-
-func (r *ExpenseRepository) Delete(ctx context.Context, expenseID uuid.UUID, userID uuid.UUID) error {
-	_, err := r.GetByID(ctx, expenseID, userID)
-	if err != nil {
-		return err
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
 	}
 
-	err = r.db.Exec(ctx, `
+	return nil
+}
+
+func (r *ExpenseRepository) DeleteWithTx(ctx context.Context, tx pgx.Tx, expenseID uuid.UUID, userID uuid.UUID) error {
+	tag, err := tx.Exec(ctx, `
 		DELETE FROM public.expense
 		WHERE id = $1 AND user_id = $2
 	`,
@@ -247,11 +404,20 @@ func (r *ExpenseRepository) Delete(ctx context.Context, expenseID uuid.UUID, use
 		userID,
 	)
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
 }
 
 func (r *ExpenseRepository) InsertWithTx(ctx context.Context, tx pgx.Tx, expense *database.Expense, recurrentExpenseID *uuid.UUID, installmentExpenseID *uuid.UUID) (uuid.UUID, error) {
 	var id uuid.UUID
+	amount := expenseAmount(expense)
 
 	err := tx.QueryRow(ctx, `
 		INSERT INTO public.expense (
@@ -260,26 +426,191 @@ func (r *ExpenseRepository) InsertWithTx(ctx context.Context, tx pgx.Tx, expense
 			payment_method_id,
 			ars_amount,
 			usd_amount,
+			currency,
+			original_amount,
+			usd_ars_rate,
+			currency_usd_rate,
+			rate_type,
+			rate_provider,
+			rate_fetched_at,
 			category_id,
 			subcategory_id,
 			recurrent_expense_id,
 			installements_expense_id,
-			date
+			date,
+			timezone,
+			local_date,
+			idempotency_key
 		) VALUES
-		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
 		RETURNING id
 	`,
 		expense.UserID,
 		expense.Description,
 		expense.PaymentMethodID,
-		expense.ARSAmount,
-		expense.USDAmount,
+		amount.ARSAmount,
+		amount.USDAmount,
+		amount.Currency,
+		amount.OriginalAmount,
+		knownRate(amount.UsdArsRate),
+		knownRate(amount.CurrencyUsdRate),
+		amount.RateType,
+		amount.RateProvider,
+		amount.RateFetchedAt,
 		expense.CategoryID,
 		expense.SubcategoryID,
 		recurrentExpenseID,
 		installmentExpenseID,
 		expense.Date,
+		expenseTimezone(expense),
+		localDate(expense.Date, expenseTimezone(expense)),
+		expense.IdempotencyKey,
 	).Scan(&id)
 
 	return id, err
 }
+
+// Expenses built without a timezone keep the default one
+func expenseTimezone(expense *database.Expense) string {
+	if expense.Timezone == "" {
+		return dates.DefaultTimezone
+	}
+	return expense.Timezone
+}
+
+/*
+Expenses of the user to revalue, with the amounts as they are stored. Days are
+compared in the timezone of each expense and both are optional. onlyInvalid
+leaves only the expenses whose ARS or USD amount is zero.
+*/
+func (r *ExpenseRepository) GetForRevaluation(ctx context.Context, userID uuid.UUID, startDate *time.Time, endDate *time.Time, onlyInvalid bool) ([]database.Expense, error) {
+	query := `SELECT
+			id,
+			user_id,
+			description,
+			payment_method_id,
+			ars_amount,
+			usd_amount,
+			currency,
+			original_amount,
+			COALESCE(usd_ars_rate, 0) AS usd_ars_rate,
+			COALESCE(currency_usd_rate, 0) AS currency_usd_rate,
+			rate_type,
+			rate_provider,
+			rate_fetched_at,
+			category_id,
+			subcategory_id,
+			recurrent_expense_id,
+			installements_expense_id,
+			date,
+			timezone,
+			idempotency_key
+		FROM public.expense
+		WHERE user_id = $1`
+
+	args := []any{userID}
+
+	if startDate != nil {
+		args = append(args, calendarDay(*startDate))
+		query += fmt.Sprintf(" AND local_date >= $%d", len(args))
+	}
+
+	if endDate != nil {
+		args = append(args, calendarDay(*endDate))
+		query += fmt.Sprintf(" AND local_date <= $%d", len(args))
+	}
+
+	if onlyInvalid {
+		query += " AND (ars_amount = 0 OR usd_amount = 0)"
+	}
+
+	query += " ORDER BY date ASC, created_date ASC"
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.Expense])
+}
+
+/*
+Replaces the amounts, rates and rate source of the expense, only if its
+amounts, currency and date are still the ones in expense. Returns
+pgx.ErrNoRows when it was changed or deleted since it was read.
+*/
+func (r *ExpenseRepository) UpdateAmountWithTx(ctx context.Context, tx pgx.Tx, expense *database.Expense, amount *database.ExpenseAmount) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE public.expense
+		SET
+			ars_amount = $1,
+			usd_amount = $2,
+			original_amount = $3,
+			usd_ars_rate = $4,
+			currency_usd_rate = $5,
+			rate_type = $6,
+			rate_provider = $7,
+			rate_fetched_at = $8
+		WHERE id = $9
+			AND user_id = $10
+			AND ars_amount = $11
+			AND usd_amount = $12
+			AND original_amount = $13
+			AND currency = $14
+			AND date = $15
+	`,
+		amount.ARSAmount,
+		amount.USDAmount,
+		amount.OriginalAmount,
+		knownRate(amount.UsdArsRate),
+		knownRate(amount.CurrencyUsdRate),
+		amount.RateType,
+		amount.RateProvider,
+		amount.RateFetchedAt,
+		expense.ID,
+		expense.UserID,
+		expense.ARSAmount,
+		expense.USDAmount,
+		expense.OriginalAmount,
+		expense.Currency,
+		expense.Date,
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// Expenses built without a currency were paid in ARS
+func expenseAmount(expense *database.Expense) *database.ExpenseAmount {
+	if expense.Currency == "" {
+		return database.ARSExpenseAmount(expense.ARSAmount, expense.USDAmount, "")
+	}
+	return expense.Amount()
+}
+
+// Unknown rates are stored as NULL
+func knownRate(rate float64) *float64 {
+	if rate == 0 {
+		return nil
+	}
+	return &rate
+}
+
+/*
+Calendar day of the expense in its timezone, stored in local_date so the day
+filters do not depend on how Postgres reads the timezone.
+*/
+func localDate(date time.Time, timezone string) time.Time {
+	return calendarDay(dates.In(date, timezone))
+}
+
+// Midnight UTC of the calendar day of t, which is how pgx sends dates
+func calendarDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
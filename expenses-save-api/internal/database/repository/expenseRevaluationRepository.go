@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExpenseRevaluationRepository struct {
+	db *database.DatabaseService
+}
+
+func NewExpenseRevaluationRepository(db *database.DatabaseService) *ExpenseRevaluationRepository {
+	return &ExpenseRevaluationRepository{db: db}
+}
+
+func (r *ExpenseRevaluationRepository) InsertWithTx(ctx context.Context, tx pgx.Tx, revaluation *database.ExpenseRevaluation) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO public.expense_revaluation (
+			run_id,
+			expense_id,
+			user_id,
+			old_ars_amount,
+			old_usd_amount,
+			new_ars_amount,
+			new_usd_amount,
+			usd_ars_rate,
+			rate_type,
+			rate_provider,
+			rate_fetched_at
+		) VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`,
+		revaluation.RunID,
+		revaluation.ExpenseID,
+		revaluation.UserID,
+		revaluation.OldARSAmount,
+		revaluation.OldUSDAmount,
+		revaluation.NewARSAmount,
+		revaluation.NewUSDAmount,
+		revaluation.UsdArsRate,
+		revaluation.RateType,
+		revaluation.RateProvider,
+		revaluation.RateFetchedAt,
+	)
+
+	return err
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type ExpenseSyncRepository struct {
+	db *database.DatabaseService
+}
+
+func NewExpenseSyncRepository(db *database.DatabaseService) *ExpenseSyncRepository {
+	return &ExpenseSyncRepository{db: db}
+}
+
+// Creates a pending sync for every destination the user has configured
+func (r *ExpenseSyncRepository) InsertForUserDestinationsWithTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, expenseID uuid.UUID, operation database.SyncOperation) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO public.expense_sync (
+			expense_id,
+			user_id,
+			destination_id,
+			operation
+		)
+		SELECT $1, ues.user_id, ues.id, $3
+		FROM public.user_expense_save ues
+		WHERE ues.user_id = $2
+	`,
+		expenseID,
+		userID,
+		operation,
+	)
+
+	return err
+}
+
+// Same as InsertForUserDestinationsWithTx for many expenses of the user in a single statement
+func (r *ExpenseSyncRepository) InsertManyForUserDestinationsWithTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, expenseIDs []uuid.UUID, operation database.SyncOperation) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO public.expense_sync (
+			expense_id,
+			user_id,
+			destination_id,
+			operation
+		)
+		SELECT e.id, ues.user_id, ues.id, $3
+		FROM unnest($1::uuid[]) AS e(id)
+		CROSS JOIN public.user_expense_save ues
+		WHERE ues.user_id = $2
+	`,
+		expenseIDs,
+		userID,
+		operation,
+	)
+
+	return err
+}
+
+/*
+Claims up to limit pending syncs that are due. Claimed rows are pushed
+forward by lease so other workers skip them, and if this worker dies
+before marking them they will be picked up again once the lease expires.
+*/
+func (r *ExpenseSyncRepository) ClaimPending(ctx context.Context, limit int, lease time.Duration) ([]database.ExpenseSync, error) {
+	rows, err := r.db.Query(ctx, `
+		UPDATE public.expense_sync
+		SET
+			attempts = attempts + 1,
+			next_attempt_date = now() + make_interval(secs => $2),
+			updated_date = now()
+		WHERE id IN (
+			SELECT id
+			FROM public.expense_sync
+			WHERE status = $3 AND next_attempt_date <= now()
+			ORDER BY created_date ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id,
+			expense_id,
+			user_id,
+			destination_id,
+			operation,
+			status,
+			attempts,
+			last_error,
+			next_attempt_date,
+			created_date,
+			updated_date
+	`,
+		limit,
+		lease.Seconds(),
+		database.SyncStatus_Pending,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	syncs, err := pgx.CollectRows(rows, pgx.RowToStructByName[database.ExpenseSync])
+	if err != nil {
+		return nil, err
+	}
+
+	return syncs, nil
+}
+
+func (r *ExpenseSyncRepository) GetByExpenseID(ctx context.Context, expenseID uuid.UUID, userID uuid.UUID) ([]database.ExpenseSync, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT
+			id,
+			expense_id,
+			user_id,
+			destination_id,
+			operation,
+			status,
+			attempts,
+			last_error,
+			next_attempt_date,
+			created_date,
+			updated_date
+		FROM public.expense_sync
+		WHERE expense_id = $1 AND user_id = $2
+		ORDER BY created_date ASC
+	`,
+		expenseID,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	syncs, err := pgx.CollectRows(rows, pgx.RowToStructByName[database.ExpenseSync])
+	if err != nil {
+		return nil, err
+	}
+
+	return syncs, nil
+}
+
+func (r *ExpenseSyncRepository) MarkDone(ctx context.Context, id uuid.UUID) error {
+	return r.db.Exec(ctx, `
+		UPDATE public.expense_sync
+		SET status = $1, last_error = NULL, updated_date = now()
+		WHERE id = $2
+	`,
+		database.SyncStatus_Done,
+		id,
+	)
+}
+
+func (r *ExpenseSyncRepository) MarkRetry(ctx context.Context, id uuid.UUID, lastError string, nextAttemptDate time.Time) error {
+	return r.db.Exec(ctx, `
+		UPDATE public.expense_sync
+		SET last_error = $1, next_attempt_date = $2, updated_date = now()
+		WHERE id = $3
+	`,
+		lastError,
+		nextAttemptDate,
+		id,
+	)
+}
+
+func (r *ExpenseSyncRepository) MarkFailed(ctx context.Context, id uuid.UUID, lastError string) error {
+	return r.db.Exec(ctx, `
+		UPDATE public.expense_sync
+		SET status = $1, last_error = $2, updated_date = now()
+		WHERE id = $3
+	`,
+		database.SyncStatus_Failed,
+		lastError,
+		id,
+	)
+}
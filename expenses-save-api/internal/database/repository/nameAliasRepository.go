@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const nameAliasUserKindAliasIndex = "name_alias_user_kind_alias_idx"
+
+type NameAliasRepository struct {
+	db *database.DatabaseService
+}
+
+func NewNameAliasRepository(db *database.DatabaseService) *NameAliasRepository {
+	return &NameAliasRepository{db: db}
+}
+
+// Reports whether the error is caused by the user already having the alias for the same kind
+func IsDuplicatedAlias(err error) bool {
+	return database.IsUniqueViolation(err, nameAliasUserKindAliasIndex)
+}
+
+func (r *NameAliasRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]database.NameAlias, error) {
+	rows, err := r.db.Query(
+		ctx,
+		"SELECT id, user_id, kind, alias, target_id, created_date FROM public.name_alias WHERE user_id = $1 ORDER BY kind ASC, alias ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.NameAlias])
+}
+
+func (r *NameAliasRepository) Insert(ctx context.Context, userID uuid.UUID, kind database.AliasKind, alias string, targetID uuid.UUID) (*database.NameAlias, error) {
+	var a database.NameAlias
+
+	err := r.db.QueryRow(
+		ctx,
+		`INSERT INTO public.name_alias (user_id, kind, alias, target_id) VALUES ($1, $2, $3, $4)
+		RETURNING id, user_id, kind, alias, target_id, created_date`,
+		userID,
+		kind,
+		alias,
+		targetID,
+	).Scan(&a.ID, &a.UserID, &a.Kind, &a.Alias, &a.TargetID, &a.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// Returns pgx.ErrNoRows if the alias does not exist for the user
+func (r *NameAliasRepository) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	var deletedID uuid.UUID
+
+	return r.db.QueryRow(
+		ctx,
+		"DELETE FROM public.name_alias WHERE id = $1 AND user_id = $2 RETURNING id",
+		id,
+		userID,
+	).Scan(&deletedID)
+}
+
+// Checks that the category, subcategory or payment method an alias points to belongs to the user
+func (r *NameAliasRepository) TargetExists(ctx context.Context, userID uuid.UUID, kind database.AliasKind, targetID uuid.UUID) (bool, error) {
+	var query string
+
+	switch kind {
+	case database.AliasKind_Category:
+		query = "SELECT EXISTS (SELECT 1 FROM public.category WHERE id = $2 AND user_id = $1)"
+	case database.AliasKind_Subcategory:
+		query = `SELECT EXISTS (
+			SELECT 1 FROM public.subcategory sc
+			JOIN public.category c ON c.id = sc.category_id
+			WHERE sc.id = $2 AND c.user_id = $1
+		)`
+	case database.AliasKind_PaymentMethod:
+		query = "SELECT EXISTS (SELECT 1 FROM public.payment_method WHERE id = $2 AND user_id = $1)"
+	default:
+		return false, nil
+	}
+
+	var exists bool
+	if err := r.db.QueryRow(ctx, query, userID, targetID).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
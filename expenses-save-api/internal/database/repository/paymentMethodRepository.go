@@ -19,7 +19,7 @@ func NewPaymentMethodRepository(db *database.DatabaseService) *PaymentMethodRepo
 func (r *PaymentMethodRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]database.PaymentMethod, error) {
 	rows, err := r.db.Query(
 		ctx,
-		"SELECT id, user_id, name FROM public.payment_method WHERE user_id = $1 ORDER BY name ASC",
+		"SELECT id, user_id, name, rate_type FROM public.payment_method WHERE user_id = $1 ORDER BY name ASC",
 		userID,
 	)
 	if err != nil {
@@ -34,15 +34,49 @@ func (r *PaymentMethodRepository) GetByUserID(ctx context.Context, userID uuid.U
 	return paymentMethods, nil
 }
 
-func (r *PaymentMethodRepository) Insert(ctx context.Context, userID uuid.UUID, name string) (*database.PaymentMethod, error) {
+// Returns nil if the payment method uses the rate type of the user
+func (r *PaymentMethodRepository) GetRateType(ctx context.Context, id uuid.UUID, userID uuid.UUID) (*database.RateType, error) {
+	var rateType *database.RateType
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT rate_type FROM public.payment_method WHERE id = $1 AND user_id = $2",
+		id,
+		userID,
+	).Scan(&rateType)
+	if err != nil {
+		return nil, err
+	}
+
+	return rateType, nil
+}
+
+func (r *PaymentMethodRepository) Exists(ctx context.Context, id uuid.UUID, userID uuid.UUID) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM public.payment_method WHERE id = $1 AND user_id = $2)",
+		id,
+		userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (r *PaymentMethodRepository) Insert(ctx context.Context, userID uuid.UUID, name string, rateType *database.RateType) (*database.PaymentMethod, error) {
 	var pm database.PaymentMethod
 
 	err := r.db.QueryRow(
 		ctx,
-		"INSERT INTO public.payment_method (user_id, name) VALUES ($1, $2) RETURNING id, user_id, name",
+		"INSERT INTO public.payment_method (user_id, name, rate_type) VALUES ($1, $2, $3) RETURNING id, user_id, name, rate_type",
 		userID,
 		name,
-	).Scan(&pm.Id, &pm.UserID, &pm.Name)
+		rateType,
+	).Scan(&pm.Id, &pm.UserID, &pm.Name, &pm.RateType)
 	if err != nil {
 		return nil, err
 	}
@@ -50,16 +84,17 @@ func (r *PaymentMethodRepository) Insert(ctx context.Context, userID uuid.UUID,
 	return &pm, nil
 }
 
-func (r *PaymentMethodRepository) Update(ctx context.Context, id uuid.UUID, userID uuid.UUID, name string) (*database.PaymentMethod, error) {
+func (r *PaymentMethodRepository) Update(ctx context.Context, id uuid.UUID, userID uuid.UUID, name string, rateType *database.RateType) (*database.PaymentMethod, error) {
 	var pm database.PaymentMethod
 
 	err := r.db.QueryRow(
 		ctx,
-		"UPDATE public.payment_method SET name = $1 WHERE id = $2 AND user_id = $3 RETURNING id, user_id, name",
+		"UPDATE public.payment_method SET name = $1, rate_type = $2 WHERE id = $3 AND user_id = $4 RETURNING id, user_id, name, rate_type",
 		name,
+		rateType,
 		id,
 		userID,
-	).Scan(&pm.Id, &pm.UserID, &pm.Name)
+	).Scan(&pm.Id, &pm.UserID, &pm.Name, &pm.RateType)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, pgx.ErrNoRows
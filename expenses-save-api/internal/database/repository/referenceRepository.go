@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Resolves the names sent in expense requests into the categories, subcategories and payment methods of the user
+type ReferenceRepository struct {
+	db *database.DatabaseService
+}
+
+func NewReferenceRepository(db *database.DatabaseService) *ReferenceRepository {
+	return &ReferenceRepository{db: db}
+}
+
+/*
+Returns, in a single query, the candidates for the payment method, category
+and subcategory names: the ones that match exactly (name or alias, ignoring
+case) and the ones whose trigram similarity is at least minSimilarity. Up to
+limit candidates are returned per kind, exact matches first and then the most
+similar. Subcategories are searched in the best category candidate, so none
+are returned if subcategoryName is empty or no category was found.
+*/
+func (r *ReferenceRepository) GetCandidates(ctx context.Context, userID uuid.UUID, paymentMethodName string, categoryName string, subcategoryName string, minSimilarity float64, limit int) ([]database.ReferenceCandidate, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`WITH payment_methods AS (
+			SELECT pm.id, pm.name,
+				COALESCE(bool_or(LOWER(pm.name) = LOWER($2) OR LOWER(a.alias) = LOWER($2)), false) AS exact,
+				GREATEST(similarity(pm.name, $2), COALESCE(MAX(similarity(a.alias, $2)), 0)) AS similarity
+			FROM public.payment_method pm
+			LEFT JOIN public.name_alias a ON a.target_id = pm.id AND a.user_id = pm.user_id AND a.kind = 'payment_method'
+			WHERE pm.user_id = $1
+			GROUP BY pm.id, pm.name
+		),
+		categories AS (
+			SELECT c.id, c.name,
+				COALESCE(bool_or(LOWER(c.name) = LOWER($3) OR LOWER(a.alias) = LOWER($3)), false) AS exact,
+				GREATEST(similarity(c.name, $3), COALESCE(MAX(similarity(a.alias, $3)), 0)) AS similarity
+			FROM public.category c
+			LEFT JOIN public.name_alias a ON a.target_id = c.id AND a.user_id = c.user_id AND a.kind = 'category'
+			WHERE c.user_id = $1
+			GROUP BY c.id, c.name
+		),
+		best_category AS (
+			SELECT id FROM categories
+			WHERE exact OR similarity >= $5
+			ORDER BY exact DESC, similarity DESC, name ASC
+			LIMIT 1
+		),
+		subcategories AS (
+			SELECT sc.id, sc.name,
+				COALESCE(bool_or(LOWER(sc.name) = LOWER($4) OR LOWER(a.alias) = LOWER($4)), false) AS exact,
+				GREATEST(similarity(sc.name, $4), COALESCE(MAX(similarity(a.alias, $4)), 0)) AS similarity
+			FROM public.subcategory sc
+			JOIN best_category bc ON bc.id = sc.category_id
+			LEFT JOIN public.name_alias a ON a.target_id = sc.id AND a.user_id = $1 AND a.kind = 'subcategory'
+			WHERE $4 <> ''
+			GROUP BY sc.id, sc.name
+		),
+		candidates AS (
+			SELECT 'payment_method' AS kind, id, name, exact, similarity FROM payment_methods
+			UNION ALL
+			SELECT 'category', id, name, exact, similarity FROM categories
+			UNION ALL
+			SELECT 'subcategory', id, name, exact, similarity FROM subcategories
+		)
+		SELECT kind, id, name, exact, similarity FROM (
+			SELECT candidates.*, row_number() OVER (PARTITION BY kind ORDER BY exact DESC, similarity DESC, name ASC) AS rank
+			FROM candidates
+			WHERE exact OR similarity >= $5
+		) ranked
+		WHERE rank <= $6
+		ORDER BY kind, rank`,
+		userID,
+		paymentMethodName,
+		categoryName,
+		subcategoryName,
+		minSimilarity,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByName[database.ReferenceCandidate])
+}
+
+func (r *ReferenceRepository) RecurrentExpenseExists(ctx context.Context, userID uuid.UUID, recurrentExpenseID uuid.UUID) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM public.recurrent_expense WHERE id = $1 AND user_id = $2)",
+		recurrentExpenseID,
+		userID,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
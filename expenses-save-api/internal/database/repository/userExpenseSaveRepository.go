@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+)
+
+type UserExpenseSaveRepository struct {
+	db *database.DatabaseService
+}
+
+func NewUserExpenseSaveRepository(db *database.DatabaseService) *UserExpenseSaveRepository {
+	return &UserExpenseSaveRepository{db: db}
+}
+
+func (r *UserExpenseSaveRepository) GetByID(ctx context.Context, id uuid.UUID) (*database.UserExpenseSave, error) {
+	var u database.UserExpenseSave
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT id, user_id, destination, info, created_date FROM public.user_expense_save WHERE id = $1",
+		id,
+	).Scan(&u.ID, &u.UserID, &u.Destination, &u.Info, &u.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type UserPreferenceRepository struct {
+	db *database.DatabaseService
+}
+
+func NewUserPreferenceRepository(db *database.DatabaseService) *UserPreferenceRepository {
+	return &UserPreferenceRepository{db: db}
+}
+
+// Users that never saved their preferences get the defaults
+func (r *UserPreferenceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*database.UserPreference, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`SELECT user_id, timezone, default_currency, default_payment_method_id, date_format, rate_type
+		FROM public.user_preference
+		WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	preference, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[database.UserPreference])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return database.DefaultUserPreference(userID), nil
+		}
+		return nil, err
+	}
+
+	return preference, nil
+}
+
+func (r *UserPreferenceRepository) Upsert(ctx context.Context, preference *database.UserPreference) (*database.UserPreference, error) {
+	rows, err := r.db.Query(
+		ctx,
+		`INSERT INTO public.user_preference (user_id, timezone, default_currency, default_payment_method_id, date_format, rate_type)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET
+			timezone = EXCLUDED.timezone,
+			default_currency = EXCLUDED.default_currency,
+			default_payment_method_id = EXCLUDED.default_payment_method_id,
+			date_format = EXCLUDED.date_format,
+			rate_type = EXCLUDED.rate_type,
+			updated_date = now()
+		RETURNING user_id, timezone, default_currency, default_payment_method_id, date_format, rate_type`,
+		preference.UserID,
+		preference.Timezone,
+		preference.DefaultCurrency,
+		preference.DefaultPaymentMethodID,
+		preference.DateFormat,
+		preference.RateType,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[database.UserPreference])
+}
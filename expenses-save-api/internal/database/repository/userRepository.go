@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+)
+
+// Users are managed by auth-service, this repository only reads them
+type UserRepository struct {
+	db *database.DatabaseService
+}
+
+func NewUserRepository(db *database.DatabaseService) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Exists(ctx context.Context, id uuid.UUID) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM public.users WHERE id = $1)",
+		id,
+	).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
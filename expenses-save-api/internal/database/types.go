@@ -1,9 +1,10 @@
 package database
 
 import (
-	"fmt"
 	"time"
 
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
 	"github.com/google/uuid"
 )
 
@@ -24,29 +25,127 @@ type UserExpenseSave struct {
 
 // This is the actual expense
 type Expense struct {
-	ID                     uuid.UUID  `db:"id" json:"id"`
-	UserID                 uuid.UUID  `db:"user_id" json:"userId"`
-	Description            string     `db:"description" json:"description"`
-	PaymentMethodID        uuid.UUID  `db:"payment_method_id" json:"paymentMethodId"`
-	ARSAmount              float64    `db:"ars_amount" json:"arsAmount"`
-	USDAmount              float64    `db:"usd_amount" json:"usdAmount"`
-	CategoryID             uuid.UUID  `db:"category_id" json:"categoryId"`
-	SubcategoryID          *uuid.UUID `db:"subcategory_id" json:"subcategoryId"`
-	RecurrentExpenseID     *uuid.UUID `db:"recurrent_expense_id" json:"recurrentExpenseId"`
-	InstallementsExpenseID *uuid.UUID `db:"installements_expense_id" json:"installementsExpenseId"`
-	Date                   time.Time  `db:"date" json:"date"`
+	ID                     uuid.UUID   `db:"id" json:"id"`
+	UserID                 uuid.UUID   `db:"user_id" json:"userId"`
+	Description            string      `db:"description" json:"description"`
+	PaymentMethodID        uuid.UUID   `db:"payment_method_id" json:"paymentMethodId"`
+	ARSAmount              money.Money `db:"ars_amount" json:"arsAmount"`
+	USDAmount              money.Money `db:"usd_amount" json:"usdAmount"`
+	Currency               string      `db:"currency" json:"currency"`                 // paid in
+	OriginalAmount         money.Money `db:"original_amount" json:"originalAmount"`    // in Currency
+	UsdArsRate             float64     `db:"usd_ars_rate" json:"usdArsRate"`           // ARS per USD, 0 if unknown
+	CurrencyUsdRate        float64     `db:"currency_usd_rate" json:"currencyUsdRate"` // Currency per USD, 0 if unknown
+	RateType               *RateType   `db:"rate_type" json:"rateType"`                // of UsdArsRate, nil if it was not converted
+	RateProvider           *string     `db:"rate_provider" json:"rateProvider"`        // see ExpenseAmount
+	RateFetchedAt          *time.Time  `db:"rate_fetched_at" json:"rateFetchedAt"`
+	CategoryID             uuid.UUID   `db:"category_id" json:"categoryId"`
+	SubcategoryID          *uuid.UUID  `db:"subcategory_id" json:"subcategoryId"`
+	RecurrentExpenseID     *uuid.UUID  `db:"recurrent_expense_id" json:"recurrentExpenseId"`
+	InstallementsExpenseID *uuid.UUID  `db:"installements_expense_id" json:"installementsExpenseId"`
+	Date                   time.Time   `db:"date" json:"date"`
+	Timezone               string      `db:"timezone" json:"timezone"`
+	IdempotencyKey         *string     `db:"idempotency_key" json:"idempotencyKey"`
+}
+
+// Sources of amounts that were not converted with a quote
+const (
+	RateProvider_User   = "user"
+	RateProvider_Import = "import"
+)
+
+// Amount of an expense in the currency it was paid in, converted to ARS and USD
+type ExpenseAmount struct {
+	Currency       string
+	OriginalAmount money.Money
+	ARSAmount      money.Money
+	USDAmount      money.Money
+	// ARS per USD
+	UsdArsRate float64
+	// Units of Currency per USD
+	CurrencyUsdRate float64
+	// Quote UsdArsRate comes from. The provider is RateProvider_User or
+	// RateProvider_Import, without rate type, when the amounts were not converted
+	RateType      *RateType
+	RateProvider  *string
+	RateFetchedAt *time.Time
+}
+
+// Amount of an expense paid in ARS whose USD amount is known. provider says where the amounts come from
+func ARSExpenseAmount(arsAmount money.Money, usdAmount money.Money, provider string) *ExpenseAmount {
+	amount := &ExpenseAmount{
+		Currency:       "ARS",
+		OriginalAmount: arsAmount,
+		ARSAmount:      arsAmount,
+		USDAmount:      usdAmount,
+	}
+
+	if provider != "" {
+		amount.RateProvider = &provider
+	}
+
+	if !usdAmount.IsZero() {
+		amount.UsdArsRate = arsAmount.Float64() / usdAmount.Float64()
+		amount.CurrencyUsdRate = amount.UsdArsRate
+	}
+
+	return amount
+}
+
+func (e *Expense) SetAmount(amount *ExpenseAmount) {
+	e.Currency = amount.Currency
+	e.OriginalAmount = amount.OriginalAmount
+	e.ARSAmount = amount.ARSAmount
+	e.USDAmount = amount.USDAmount
+	e.UsdArsRate = amount.UsdArsRate
+	e.CurrencyUsdRate = amount.CurrencyUsdRate
+	e.RateType = amount.RateType
+	e.RateProvider = amount.RateProvider
+	e.RateFetchedAt = amount.RateFetchedAt
+}
+
+func (e *Expense) Amount() *ExpenseAmount {
+	return &ExpenseAmount{
+		Currency:        e.Currency,
+		OriginalAmount:  e.OriginalAmount,
+		ARSAmount:       e.ARSAmount,
+		USDAmount:       e.USDAmount,
+		UsdArsRate:      e.UsdArsRate,
+		CurrencyUsdRate: e.CurrencyUsdRate,
+		RateType:        e.RateType,
+		RateProvider:    e.RateProvider,
+		RateFetchedAt:   e.RateFetchedAt,
+	}
 }
 
 type ExpenseSheetsRow struct {
-	ID                uuid.UUID `db:"id"`
-	Date              time.Time `db:"date"`
-	Description       string    `db:"description"`
-	PaymentMethodName string    `db:"payment_method_name"`
-	ARSAmount         float64   `db:"ars_amount"`
-	USDAmount         float64   `db:"usd_amount"`
-	CategoryName      string    `db:"category_name"`
-	SubcategoryName   *string   `db:"subcategory_name"`
-	CreatedDate       time.Time `db:"created_date"`
+	ID                uuid.UUID   `db:"id"`
+	Date              time.Time   `db:"date"`
+	Timezone          string      `db:"timezone"`
+	Description       string      `db:"description"`
+	PaymentMethodName string      `db:"payment_method_name"`
+	ARSAmount         money.Money `db:"ars_amount"`
+	USDAmount         money.Money `db:"usd_amount"`
+	Currency          string      `db:"currency"`
+	OriginalAmount    money.Money `db:"original_amount"`
+	CategoryName      string      `db:"category_name"`
+	SubcategoryName   *string     `db:"subcategory_name"`
+	CreatedDate       time.Time   `db:"created_date"`
+	// Of the owner of the expense
+	DateFormat DateFormat `db:"date_format"`
+}
+
+// Go layout of the date format of the owner, YYYY-MM-DD if it is unknown
+func (r *ExpenseSheetsRow) DateLayout() string {
+	if layout, ok := dates.Layout(string(r.DateFormat)); ok {
+		return layout
+	}
+	return dates.DateLayout
+}
+
+// Currency expenses can be paid in, by ISO 4217 code
+type Currency struct {
+	Code string `db:"code" json:"code"`
+	Name string `db:"name" json:"name"`
 }
 
 type Category struct {
@@ -65,20 +164,22 @@ type PaymentMethod struct {
 	Id     uuid.UUID `db:"id" json:"id"`
 	UserID uuid.UUID `db:"user_id" json:"userId"`
 	Name   string    `db:"name" json:"name"`
+	// Overrides the rate type of the user preferences
+	RateType *RateType `db:"rate_type" json:"rateType"`
 }
 
 type RecurrentExpense struct {
-	ID              uuid.UUID  `db:"id" json:"id"`
-	UserID          uuid.UUID  `db:"user_id" json:"userId"`
-	Description     string     `db:"description" json:"description"`
-	PaymentMethodID uuid.UUID  `db:"payment_method_id" json:"paymentMethodId"`
-	ARSAmount       *float64   `db:"ars_amount" json:"arsAmount"`
-	USDAmount       *float64   `db:"usd_amount" json:"usdAmount"`
-	CategoryID      uuid.UUID  `db:"category_id" json:"categoryId"`
-	SubcategoryID   *uuid.UUID `db:"subcategory_id" json:"subcategoryId"`
-	StartDate       time.Time  `db:"start_date" json:"startDate"`
-	EndDate         *time.Time `db:"end_date" json:"endDate"`
-	CreatedDate     time.Time  `db:"created_date" json:"createdDate"`
+	ID              uuid.UUID    `db:"id" json:"id"`
+	UserID          uuid.UUID    `db:"user_id" json:"userId"`
+	Description     string       `db:"description" json:"description"`
+	PaymentMethodID uuid.UUID    `db:"payment_method_id" json:"paymentMethodId"`
+	ARSAmount       *money.Money `db:"ars_amount" json:"arsAmount"`
+	USDAmount       *money.Money `db:"usd_amount" json:"usdAmount"`
+	CategoryID      uuid.UUID    `db:"category_id" json:"categoryId"`
+	SubcategoryID   *uuid.UUID   `db:"subcategory_id" json:"subcategoryId"`
+	StartDate       time.Time    `db:"start_date" json:"startDate"`
+	EndDate         *time.Time   `db:"end_date" json:"endDate"`
+	CreatedDate     time.Time    `db:"created_date" json:"createdDate"`
 }
 
 type InstallementsExpense struct {
@@ -88,28 +189,189 @@ type InstallementsExpense struct {
 	CreatedDate time.Time `db:"created_date" json:"createdDate"`
 }
 
-type GoogleSheetsInfo struct {
-	SheetID   string `json:"sheetId"`
-	SheetName string `json:"sheetName"`
+type SyncStatus string
+
+const (
+	SyncStatus_Pending SyncStatus = "pending"
+	SyncStatus_Done    SyncStatus = "done"
+	SyncStatus_Failed  SyncStatus = "failed"
+)
+
+type SyncOperation string
+
+const (
+	SyncOperation_Insert SyncOperation = "insert"
+	SyncOperation_Update SyncOperation = "update"
+	SyncOperation_Delete SyncOperation = "delete"
+)
+
+// Pending delivery of an expense to one of the user destinations
+type ExpenseSync struct {
+	ID              uuid.UUID     `db:"id" json:"id"`
+	ExpenseID       uuid.UUID     `db:"expense_id" json:"expenseId"`
+	UserID          uuid.UUID     `db:"user_id" json:"userId"`
+	DestinationID   uuid.UUID     `db:"destination_id" json:"destinationId"`
+	Operation       SyncOperation `db:"operation" json:"operation"`
+	Status          SyncStatus    `db:"status" json:"status"`
+	Attempts        int           `db:"attempts" json:"attempts"`
+	LastError       *string       `db:"last_error" json:"lastError"`
+	NextAttemptDate time.Time     `db:"next_attempt_date" json:"nextAttemptDate"`
+	CreatedDate     time.Time     `db:"created_date" json:"createdDate"`
+	UpdatedDate     time.Time     `db:"updated_date" json:"updatedDate"`
 }
 
-func (ues *UserExpenseSave) GetGoogleSheetsInfo() (*GoogleSheetsInfo, error) {
-	if ues.Destination != DestinationType_GoogleSheets {
-		return nil, nil
+type RuleField string
+
+const (
+	RuleField_Description RuleField = "description"
+	RuleField_Vendor      RuleField = "vendor"
+)
+
+type RuleMatchType string
+
+const (
+	RuleMatchType_Substring RuleMatchType = "substring"
+	RuleMatchType_Regex     RuleMatchType = "regex"
+)
+
+// Assigns a category to the expenses whose description or vendor matches the pattern
+type CategoryRule struct {
+	ID                    uuid.UUID     `db:"id" json:"id"`
+	UserID                uuid.UUID     `db:"user_id" json:"userId"`
+	Name                  string        `db:"name" json:"name"`
+	Priority              int           `db:"priority" json:"priority"`
+	Field                 RuleField     `db:"field" json:"field"`
+	MatchType             RuleMatchType `db:"match_type" json:"matchType"`
+	Pattern               string        `db:"pattern" json:"pattern"`
+	MinAmount             *money.Money  `db:"min_amount" json:"minAmount"`
+	MaxAmount             *money.Money  `db:"max_amount" json:"maxAmount"`
+	PaymentMethodID       *uuid.UUID    `db:"payment_method_id" json:"paymentMethodId"`
+	CategoryID            uuid.UUID     `db:"category_id" json:"categoryId"`
+	SubcategoryID         *uuid.UUID    `db:"subcategory_id" json:"subcategoryId"`
+	NormalizedDescription *string       `db:"normalized_description" json:"normalizedDescription"`
+	Enabled               bool          `db:"enabled" json:"enabled"`
+	CreatedDate           time.Time     `db:"created_date" json:"createdDate"`
+}
+
+type AliasKind string
+
+const (
+	AliasKind_Category      AliasKind = "category"
+	AliasKind_Subcategory   AliasKind = "subcategory"
+	AliasKind_PaymentMethod AliasKind = "payment_method"
+)
+
+// Alternative name of a category, subcategory or payment method
+type NameAlias struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	UserID      uuid.UUID `db:"user_id" json:"userId"`
+	Kind        AliasKind `db:"kind" json:"kind"`
+	Alias       string    `db:"alias" json:"alias"`
+	TargetID    uuid.UUID `db:"target_id" json:"targetId"`
+	CreatedDate time.Time `db:"created_date" json:"createdDate"`
+}
+
+/*
+Category, subcategory or payment method that may be the one referenced by a
+name. Exact means the name or one of its aliases is the same ignoring case,
+otherwise Similarity (from 0 to 1) says how close it is.
+*/
+type ReferenceCandidate struct {
+	Kind       AliasKind `db:"kind"`
+	ID         uuid.UUID `db:"id"`
+	Name       string    `db:"name"`
+	Exact      bool      `db:"exact"`
+	Similarity float64   `db:"similarity"`
+}
+
+// USD/ARS exchange rate used to convert amounts
+type RateType string
+
+const (
+	// Buying AL30 in ARS and selling AL30D
+	RateType_MEP RateType = "mep"
+	// Buying GD30 in ARS and selling GD30C, dollars paid abroad
+	RateType_CCL      RateType = "ccl"
+	RateType_Official RateType = "official"
+	// Official rate with the taxes of purchases abroad
+	RateType_Card RateType = "card"
+)
+
+func (t RateType) IsValid() bool {
+	switch t {
+	case RateType_MEP, RateType_CCL, RateType_Official, RateType_Card:
+		return true
 	}
+	return false
+}
+
+type DateFormat string
 
-	sheetID, ok := ues.Info["sheetId"].(string)
-	if !ok {
-		return nil, fmt.Errorf("sheetId is not a string or is missing")
+const (
+	DateFormat_ISO        DateFormat = "YYYY-MM-DD"
+	DateFormat_DayFirst   DateFormat = "DD/MM/YYYY"
+	DateFormat_MonthFirst DateFormat = "MM/DD/YYYY"
+)
+
+// Users without preferences use DefaultUserPreference
+type UserPreference struct {
+	UserID                 uuid.UUID  `db:"user_id" json:"userId"`
+	Timezone               string     `db:"timezone" json:"timezone"`
+	DefaultCurrency        string     `db:"default_currency" json:"defaultCurrency"`
+	DefaultPaymentMethodID *uuid.UUID `db:"default_payment_method_id" json:"defaultPaymentMethodId"`
+	DateFormat             DateFormat `db:"date_format" json:"dateFormat"`
+	RateType               RateType   `db:"rate_type" json:"rateType"`
+}
+
+func DefaultUserPreference(userID uuid.UUID) *UserPreference {
+	return &UserPreference{
+		UserID:          userID,
+		Timezone:        dates.DefaultTimezone,
+		DefaultCurrency: "ARS",
+		DateFormat:      DateFormat_ISO,
+		RateType:        RateType_MEP,
 	}
+}
 
-	sheetName, ok := ues.Info["sheetName"].(string)
-	if !ok {
-		return nil, fmt.Errorf("sheetName is not a string or is missing")
+// Go layout of the date format, YYYY-MM-DD if it is unknown
+func (p *UserPreference) DateLayout() string {
+	if layout, ok := dates.Layout(string(p.DateFormat)); ok {
+		return layout
 	}
+	return dates.DateLayout
+}
+
+// Last rate of a day, Date is midnight UTC of the Buenos Aires day
+type ExchangeRate struct {
+	RateType   RateType           `db:"rate_type" json:"rateType"`
+	Date       time.Time          `db:"date" json:"date"`
+	Rate       float64            `db:"rate" json:"rate"`
+	Provider   string             `db:"provider" json:"provider"`
+	Components map[string]float64 `db:"components" json:"components"`
+	FetchedAt  time.Time          `db:"fetched_at" json:"fetchedAt"`
+}
+
+// Units of a currency other than ARS and USD per USD on a Buenos Aires day
+type CurrencyRate struct {
+	Currency  string    `db:"currency" json:"currency"`
+	Date      time.Time `db:"date" json:"date"`
+	Rate      float64   `db:"rate" json:"rate"`
+	FetchedAt time.Time `db:"fetched_at" json:"fetchedAt"`
+}
 
-	return &GoogleSheetsInfo{
-		SheetID:   sheetID,
-		SheetName: sheetName,
-	}, nil
+// Change of the amounts of an expense made by a revaluation run
+type ExpenseRevaluation struct {
+	ID            uuid.UUID   `db:"id" json:"id"`
+	RunID         uuid.UUID   `db:"run_id" json:"runId"`
+	ExpenseID     uuid.UUID   `db:"expense_id" json:"expenseId"`
+	UserID        uuid.UUID   `db:"user_id" json:"userId"`
+	OldARSAmount  money.Money `db:"old_ars_amount" json:"oldArsAmount"`
+	OldUSDAmount  money.Money `db:"old_usd_amount" json:"oldUsdAmount"`
+	NewARSAmount  money.Money `db:"new_ars_amount" json:"newArsAmount"`
+	NewUSDAmount  money.Money `db:"new_usd_amount" json:"newUsdAmount"`
+	UsdArsRate    float64     `db:"usd_ars_rate" json:"usdArsRate"`
+	RateType      RateType    `db:"rate_type" json:"rateType"`
+	RateProvider  string      `db:"rate_provider" json:"rateProvider"`
+	RateFetchedAt time.Time   `db:"rate_fetched_at" json:"rateFetchedAt"`
+	CreatedDate   time.Time   `db:"created_date" json:"createdDate"`
 }
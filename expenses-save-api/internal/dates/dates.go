@@ -0,0 +1,138 @@
+package dates
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Timezone of expenses that were saved without one
+const DefaultTimezone = "America/Argentina/Buenos_Aires"
+
+// Format of date only values, like the ones in the sheets
+const DateLayout = "2006-01-02"
+
+// Go layouts of the date formats users can choose
+var layouts = map[string]string{
+	"YYYY-MM-DD": DateLayout,
+	"DD/MM/YYYY": "02/01/2006",
+	"MM/DD/YYYY": "01/02/2006",
+}
+
+// Go layout of a date format like DD/MM/YYYY
+func Layout(format string) (string, bool) {
+	layout, ok := layouts[format]
+	return layout, ok
+}
+
+var offsetRegex = regexp.MustCompile(`^([+-])(\d{2}):(\d{2})$`)
+
+/*
+Loads an IANA timezone (e.g. America/Argentina/Buenos_Aires) or a fixed UTC
+offset like -03:00. An empty timezone is the default one.
+*/
+func LoadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	if match := offsetRegex.FindStringSubmatch(timezone); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("invalid timezone offset %s", timezone)
+		}
+
+		offset := hours*3600 + minutes*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(timezone, offset), nil
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %w", timezone, err)
+	}
+	return loc, nil
+}
+
+/*
+Parses the date of an expense, either a date or an RFC3339 timestamp, and
+returns it together with the timezone to store. Dates can be YYYY-MM-DD or use
+layout, the date format of the user.
+
+timezone is where the expense happened and is optional, defaultTimezone (the
+one of the user) is used when it is empty. Dates are midnight in the timezone.
+Timestamps without timezone keep their own offset, unless it is the same as the
+one of defaultTimezone at that instant.
+*/
+func ParseExpenseDate(value string, timezone string, defaultTimezone string, layout string) (time.Time, string, error) {
+	explicit := timezone != ""
+	if !explicit {
+		timezone = defaultTimezone
+	}
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	loc, err := LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	if date, err := ParseDate(value, layout, loc); err == nil {
+		return date, timezone, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid date %s, expected %s or RFC3339", value, formatName(layout))
+	}
+
+	_, offset := timestamp.Zone()
+	_, locOffset := timestamp.In(loc).Zone()
+	if explicit || offset == locOffset {
+		return timestamp.In(loc), timezone, nil
+	}
+
+	return timestamp, formatOffset(offset), nil
+}
+
+// Parses a date as midnight in loc. YYYY-MM-DD is always accepted besides layout
+func ParseDate(value string, layout string, loc *time.Location) (time.Time, error) {
+	date, err := time.ParseInLocation(DateLayout, value, loc)
+	if err == nil || layout == "" || layout == DateLayout {
+		return date, err
+	}
+
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// Returns t in the timezone. Unknown timezones fall back to the default one
+func In(t time.Time, timezone string) time.Time {
+	loc, err := LoadLocation(timezone)
+	if err != nil {
+		loc, _ = LoadLocation(DefaultTimezone)
+	}
+	return t.In(loc)
+}
+
+func formatName(layout string) string {
+	for format, l := range layouts {
+		if l == layout && layout != DateLayout {
+			return "YYYY-MM-DD, " + format
+		}
+	}
+	return "YYYY-MM-DD"
+}
+
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
@@ -0,0 +1,54 @@
+package destination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/google/uuid"
+)
+
+/*
+A place where expenses are copied to after being saved in the database.
+Both methods have to be idempotent since syncs are retried.
+*/
+type Destination interface {
+	Type() database.DestinationType
+	// Creates the expense in the destination or updates it if it is already there
+	Upsert(ctx context.Context, destination *database.UserExpenseSave, expense *database.ExpenseSheetsRow) error
+	// Removes the expense from the destination. Missing expenses are ignored
+	Delete(ctx context.Context, destination *database.UserExpenseSave, expenseID uuid.UUID) error
+}
+
+/*
+Destinations that can write many expenses at once implement this as well, so
+the sync worker sends them together instead of one request per expense.
+UpsertMany has the same semantics as calling Upsert for each expense.
+*/
+type BatchDestination interface {
+	Destination
+	UpsertMany(ctx context.Context, destination *database.UserExpenseSave, expenses []*database.ExpenseSheetsRow) error
+}
+
+// Typed configuration of a destination, decoded from UserExpenseSave.Info
+type Config interface {
+	Validate() error
+}
+
+func DecodeConfig(info map[string]interface{}, config Config) error {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode destination info: %w", err)
+	}
+
+	if err := json.Unmarshal(raw, config); err != nil {
+		return fmt.Errorf("failed to decode destination info: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid destination info: %w", err)
+	}
+
+	return nil
+}
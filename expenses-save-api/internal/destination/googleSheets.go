@@ -0,0 +1,175 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
+	"github.com/google/uuid"
+)
+
+type GoogleSheetsConfig struct {
+	SheetID   string `json:"sheetId"`
+	SheetName string `json:"sheetName"`
+}
+
+func (c *GoogleSheetsConfig) Validate() error {
+	if c.SheetID == "" {
+		return fmt.Errorf("sheetId is missing")
+	}
+
+	if c.SheetName == "" {
+		return fmt.Errorf("sheetName is missing")
+	}
+
+	return nil
+}
+
+type GoogleSheetsDestination struct {
+	sheetsService *sheets.SheetsService
+	// *sync.Mutex by sheet, see lockSheet
+	sheetLocks sync.Map
+}
+
+func NewGoogleSheetsDestination(sheetsService *sheets.SheetsService) *GoogleSheetsDestination {
+	return &GoogleSheetsDestination{sheetsService: sheetsService}
+}
+
+/*
+Finding a row and then appending, updating or deleting it are separate
+requests. Without the lock two syncs of the same expense could both append
+it, or a delete could shift the rows under an update. It only covers this
+process, the sheet is not locked for other clients.
+*/
+func (d *GoogleSheetsDestination) lockSheet(config *GoogleSheetsConfig) func() {
+	lock, _ := d.sheetLocks.LoadOrStore(config.SheetID+"!"+config.SheetName, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+func (d *GoogleSheetsDestination) Type() database.DestinationType {
+	return database.DestinationType_GoogleSheets
+}
+
+func (d *GoogleSheetsDestination) Upsert(ctx context.Context, destination *database.UserExpenseSave, expense *database.ExpenseSheetsRow) error {
+	var config GoogleSheetsConfig
+	if err := DecodeConfig(destination.Info, &config); err != nil {
+		return err
+	}
+
+	row, err := SheetsRow(expense)
+	if err != nil {
+		return err
+	}
+
+	defer d.lockSheet(&config)()
+
+	rowNumber, err := d.sheetsService.FindRowByID(config.SheetID, config.SheetName, expense.ID.String())
+	if err != nil {
+		return fmt.Errorf("failed to find expense row: %w", err)
+	}
+
+	if rowNumber == 0 {
+		return d.sheetsService.AppendRow(config.SheetID, config.SheetName, &row)
+	}
+
+	return d.sheetsService.UpdateRow(config.SheetID, config.SheetName, rowNumber, &row)
+}
+
+// Reads the ID column once, then appends the new expenses and updates the existing ones with one request each
+func (d *GoogleSheetsDestination) UpsertMany(ctx context.Context, destination *database.UserExpenseSave, expenses []*database.ExpenseSheetsRow) error {
+	var config GoogleSheetsConfig
+	if err := DecodeConfig(destination.Info, &config); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(expenses))
+	for _, expense := range expenses {
+		ids = append(ids, expense.ID.String())
+	}
+
+	defer d.lockSheet(&config)()
+
+	rowNumbers, err := d.sheetsService.FindRowsByIDs(config.SheetID, config.SheetName, ids)
+	if err != nil {
+		return fmt.Errorf("failed to find expense rows: %w", err)
+	}
+
+	var newRows [][]interface{}
+	updatedRows := make(map[int][]interface{})
+
+	for _, expense := range expenses {
+		row, err := SheetsRow(expense)
+		if err != nil {
+			return err
+		}
+
+		if rowNumber, ok := rowNumbers[expense.ID.String()]; ok {
+			updatedRows[rowNumber] = row
+		} else {
+			newRows = append(newRows, row)
+		}
+	}
+
+	if len(updatedRows) > 0 {
+		if err := d.sheetsService.UpdateRows(config.SheetID, config.SheetName, updatedRows); err != nil {
+			return err
+		}
+	}
+
+	if len(newRows) > 0 {
+		if err := d.sheetsService.AppendRows(config.SheetID, config.SheetName, newRows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *GoogleSheetsDestination) Delete(ctx context.Context, destination *database.UserExpenseSave, expenseID uuid.UUID) error {
+	var config GoogleSheetsConfig
+	if err := DecodeConfig(destination.Info, &config); err != nil {
+		return err
+	}
+
+	defer d.lockSheet(&config)()
+
+	rowNumber, err := d.sheetsService.FindRowByID(config.SheetID, config.SheetName, expenseID.String())
+	if err != nil {
+		return fmt.Errorf("failed to find expense row: %w", err)
+	}
+
+	if rowNumber == 0 {
+		return nil
+	}
+
+	return d.sheetsService.DeleteRow(config.SheetID, config.SheetName, rowNumber)
+}
+
+// Row layout used in the sheet. The expense ID is always the first column.
+// Amounts are sent as numbers with two decimals
+func SheetsRow(expense *database.ExpenseSheetsRow) ([]interface{}, error) {
+	// The sheet only has the calendar day, in the timezone of the expense and the date format of the user
+	loc, err := dates.LoadLocation(expense.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expense timezone: %w", err)
+	}
+
+	return []interface{}{
+		expense.ID,
+		expense.Date.In(loc).Format(expense.DateLayout()),
+		expense.Description,
+		expense.PaymentMethodName,
+		expense.ARSAmount,
+		expense.USDAmount,
+		expense.CategoryName,
+		expense.SubcategoryName,
+		expense.CreatedDate,
+		expense.Currency,
+		expense.OriginalAmount,
+	}, nil
+}
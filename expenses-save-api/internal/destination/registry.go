@@ -0,0 +1,30 @@
+package destination
+
+import (
+	"fmt"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+type Registry struct {
+	destinations map[database.DestinationType]Destination
+}
+
+func NewRegistry(destinations ...Destination) *Registry {
+	r := &Registry{destinations: make(map[database.DestinationType]Destination, len(destinations))}
+
+	for _, d := range destinations {
+		r.destinations[d.Type()] = d
+	}
+
+	return r
+}
+
+func (r *Registry) Get(destinationType database.DestinationType) (Destination, error) {
+	d, ok := r.destinations[destinationType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported destination type %s", destinationType)
+	}
+
+	return d, nil
+}
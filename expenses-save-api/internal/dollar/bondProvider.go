@@ -0,0 +1,155 @@
+package dollar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+// Gets bond prices from the stock market API (STOCK_MARKET_API_URL)
+type BondPriceClient struct {
+	client     *http.Client
+	apiBaseURL string
+}
+
+func NewBondPriceClient(client *http.Client, apiBaseURL string) *BondPriceClient {
+	return &BondPriceClient{client: client, apiBaseURL: apiBaseURL}
+}
+
+func (c *BondPriceClient) GetBondPrice(ctx context.Context, ticker string) (float64, error) {
+	type bondRequest struct {
+		Market    string `json:"market"`
+		Ticker    string `json:"ticker"`
+		AssetType string `json:"assetType"`
+	}
+
+	type bondResponse struct {
+		Value               float64 `json:"value"`
+		Change              float64 `json:"change"`
+		ChangePct           float64 `json:"changePct"`
+		Ticker              string  `json:"ticker"`
+		Market              string  `json:"market"`
+		AssetType           *string `json:"assetType"`
+		UnitsForTickerPrice int     `json:"unitsForTickerPrice"`
+		Currency            string  `json:"currency"`
+		Source              string  `json:"source"`
+	}
+
+	payload := bondRequest{
+		Market:    "BCBA",
+		Ticker:    ticker,
+		AssetType: "BOND",
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal %s request payload: %v", ticker, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiBaseURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s request: %v", ticker, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch %s price: %v", ticker, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("API returned non-200 status code for %s: %d", ticker, resp.StatusCode)
+	}
+
+	var bondResp bondResponse
+	if err := json.NewDecoder(resp.Body).Decode(&bondResp); err != nil {
+		return 0, fmt.Errorf("failed to decode %s response: %v", ticker, err)
+	}
+
+	return bondResp.Value, nil
+}
+
+/*
+Rate implied by buying a bond in ARS and selling it in USD, which is the
+price of the ARS ticker over the price of the USD one.
+*/
+type bondRateProvider struct {
+	rateType  database.RateType
+	arsTicker string
+	usdTicker string
+	prices    *BondPriceClient
+}
+
+// MEP rate from AL30/AL30D
+func NewMEPProvider(prices *BondPriceClient) RateProvider {
+	return &bondRateProvider{rateType: database.RateType_MEP, arsTicker: "AL30", usdTicker: "AL30D", prices: prices}
+}
+
+// CCL rate from GD30/GD30C, where GD30C is settled in dollars abroad
+func NewCCLProvider(prices *BondPriceClient) RateProvider {
+	return &bondRateProvider{rateType: database.RateType_CCL, arsTicker: "GD30", usdTicker: "GD30C", prices: prices}
+}
+
+func (p *bondRateProvider) Type() database.RateType {
+	return p.rateType
+}
+
+func (p *bondRateProvider) Name() string {
+	return "stock_market"
+}
+
+func (p *bondRateProvider) FetchRate(ctx context.Context) (*Quote, error) {
+	type result struct {
+		price float64
+		err   error
+	}
+
+	arsChan := make(chan result)
+	usdChan := make(chan result)
+
+	go func() {
+		price, err := p.prices.GetBondPrice(ctx, p.arsTicker)
+		arsChan <- result{price: price, err: err}
+	}()
+
+	go func() {
+		price, err := p.prices.GetBondPrice(ctx, p.usdTicker)
+		usdChan <- result{price: price, err: err}
+	}()
+
+	arsResult := <-arsChan
+	usdResult := <-usdChan
+
+	if arsResult.err != nil {
+		return nil, arsResult.err
+	}
+
+	if usdResult.err != nil {
+		return nil, usdResult.err
+	}
+
+	if arsResult.price == 0 {
+		return nil, fmt.Errorf("%s price cannot be zero", p.arsTicker)
+	}
+
+	if usdResult.price == 0 {
+		return nil, fmt.Errorf("%s price cannot be zero", p.usdTicker)
+	}
+
+	return &Quote{
+		Type:     p.rateType,
+		Provider: p.Name(),
+		Rate:     roundRate(arsResult.price / usdResult.price),
+		Components: map[string]float64{
+			p.arsTicker: arsResult.price,
+			p.usdTicker: usdResult.price,
+		},
+		FetchedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package dollar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+// Stand-in of the stock market API that answers with the price of each ticker
+func newBondServer(t *testing.T, prices map[string]float64) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Ticker string `json:"ticker"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		price, ok := prices[req.Ticker]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"value": price, "ticker": req.Ticker})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestBondRateProvider(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   func(*BondPriceClient) RateProvider
+		prices     map[string]float64
+		rateType   database.RateType
+		rate       float64
+		components map[string]float64
+		wantErr    bool
+	}{
+		{
+			name:       "mep",
+			provider:   NewMEPProvider,
+			prices:     map[string]float64{"AL30": 81234.5, "AL30D": 65.3},
+			rateType:   database.RateType_MEP,
+			rate:       1244.02,
+			components: map[string]float64{"AL30": 81234.5, "AL30D": 65.3},
+		},
+		{
+			name:       "ccl",
+			provider:   NewCCLProvider,
+			prices:     map[string]float64{"GD30": 90000, "GD30C": 70},
+			rateType:   database.RateType_CCL,
+			rate:       1285.71,
+			components: map[string]float64{"GD30": 90000, "GD30C": 70},
+		},
+		{
+			name:     "zero usd price",
+			provider: NewMEPProvider,
+			prices:   map[string]float64{"AL30": 81234.5, "AL30D": 0},
+			wantErr:  true,
+		},
+		{
+			name:     "zero ars price",
+			provider: NewMEPProvider,
+			prices:   map[string]float64{"AL30": 0, "AL30D": 65.3},
+			wantErr:  true,
+		},
+		{
+			name:     "missing ticker",
+			provider: NewCCLProvider,
+			prices:   map[string]float64{"GD30": 90000},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newBondServer(t, tt.prices)
+			provider := tt.provider(NewBondPriceClient(server.Client(), server.URL))
+
+			quote, err := provider.FetchRate(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got rate %v", quote.Rate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if quote.Type != tt.rateType {
+				t.Errorf("type = %s, want %s", quote.Type, tt.rateType)
+			}
+			if quote.Provider != "stock_market" {
+				t.Errorf("provider = %s, want stock_market", quote.Provider)
+			}
+			if quote.Rate != tt.rate {
+				t.Errorf("rate = %v, want %v", quote.Rate, tt.rate)
+			}
+			for name, price := range tt.components {
+				if quote.Components[name] != price {
+					t.Errorf("component %s = %v, want %v", name, quote.Components[name], price)
+				}
+			}
+		})
+	}
+}
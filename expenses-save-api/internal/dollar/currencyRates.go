@@ -0,0 +1,106 @@
+package dollar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+)
+
+/*
+Rates of other currencies against USD from an ExchangeRate-API compatible
+endpoint (CURRENCY_RATE_API_URL). They are cached for EXCHANGE_RATE_TTL minutes
+and, like the USD/ARS rates, the last ones are used when the API fails. These
+are the current rates, the RateCollector stores them by day for past expenses.
+*/
+type CurrencyRateClient struct {
+	client    *http.Client
+	url       string
+	rates     map[string]float64
+	fetchedAt time.Time
+	failedAt  time.Time
+	mutex     sync.Mutex
+}
+
+func NewCurrencyRateClient(client *http.Client, url string) *CurrencyRateClient {
+	return &CurrencyRateClient{client: client, url: url}
+}
+
+// Units of the currency per USD
+func (c *CurrencyRateClient) GetRate(ctx context.Context, currency string) (float64, error) {
+	rates, _, err := c.GetRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rate, ok := rates[currency]
+	if !ok || rate == 0 {
+		return 0, fmt.Errorf("no USD rate for currency %s", currency)
+	}
+
+	return rate, nil
+}
+
+// Units of every currency per USD and when they were fetched. The map must not be modified
+func (c *CurrencyRateClient) GetRates(ctx context.Context) (map[string]float64, time.Time, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	expired := time.Since(c.fetchedAt) > time.Duration(*env.EXCHANGE_RATE_TTL)*time.Minute
+	retrying := c.rates != nil && time.Since(c.failedAt) < retryInterval
+
+	if c.rates == nil || (expired && !retrying) {
+		rates, err := c.fetchRates(ctx)
+		if err != nil {
+			c.failedAt = time.Now()
+			if c.rates == nil {
+				return nil, time.Time{}, err
+			}
+			log.Printf("using last known currency rates from %s: %v", c.fetchedAt.Format(time.RFC3339), err)
+		} else {
+			c.rates = rates
+			c.fetchedAt = time.Now()
+			c.failedAt = time.Time{}
+		}
+	}
+
+	return c.rates, c.fetchedAt, nil
+}
+
+func (c *CurrencyRateClient) fetchRates(ctx context.Context) (map[string]float64, error) {
+	type ratesResponse struct {
+		Result string             `json:"result"`
+		Rates  map[string]float64 `json:"rates"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create currency rates request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch currency rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned non-200 status code for currency rates: %d", resp.StatusCode)
+	}
+
+	var ratesResp ratesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ratesResp); err != nil {
+		return nil, fmt.Errorf("failed to decode currency rates response: %v", err)
+	}
+
+	if ratesResp.Result != "success" || len(ratesResp.Rates) == 0 {
+		return nil, fmt.Errorf("currency rates API returned no rates")
+	}
+
+	return ratesResp.Rates, nil
+}
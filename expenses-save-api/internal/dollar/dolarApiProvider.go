@@ -0,0 +1,114 @@
+package dollar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+// Rate from a DolarApi compatible endpoint, e.g. https://dolarapi.com/v1/dolares/oficial
+type dolarApiProvider struct {
+	rateType database.RateType
+	client   *http.Client
+	url      string
+}
+
+// Official rate (OFFICIAL_RATE_API_URL)
+func NewOfficialProvider(client *http.Client, url string) RateProvider {
+	return NewDolarApiProvider(database.RateType_Official, client, url)
+}
+
+func NewDolarApiProvider(rateType database.RateType, client *http.Client, url string) RateProvider {
+	return &dolarApiProvider{rateType: rateType, client: client, url: url}
+}
+
+func (p *dolarApiProvider) Type() database.RateType {
+	return p.rateType
+}
+
+func (p *dolarApiProvider) Name() string {
+	return "dolarapi"
+}
+
+func (p *dolarApiProvider) FetchRate(ctx context.Context) (*Quote, error) {
+	type dolarApiResponse struct {
+		Compra float64 `json:"compra"`
+		Venta  float64 `json:"venta"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s rate request: %v", p.rateType, err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s rate: %v", p.rateType, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned non-200 status code for %s rate: %d", p.rateType, resp.StatusCode)
+	}
+
+	var rateResp dolarApiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rateResp); err != nil {
+		return nil, fmt.Errorf("failed to decode %s rate response: %v", p.rateType, err)
+	}
+
+	if rateResp.Venta == 0 {
+		return nil, fmt.Errorf("%s rate cannot be zero", p.rateType)
+	}
+
+	// Expenses are purchases, so the selling price is the one that applies
+	return &Quote{
+		Type:     p.rateType,
+		Provider: p.Name(),
+		Rate:     roundRate(rateResp.Venta),
+		Components: map[string]float64{
+			"compra": rateResp.Compra,
+			"venta":  rateResp.Venta,
+		},
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// Card rate, the official one plus the taxes on purchases abroad (CARD_RATE_SURCHARGE)
+type cardRateProvider struct {
+	official  RateProvider
+	surcharge float64
+}
+
+func NewCardProvider(official RateProvider, surcharge float64) RateProvider {
+	return &cardRateProvider{official: official, surcharge: surcharge}
+}
+
+func (p *cardRateProvider) Type() database.RateType {
+	return database.RateType_Card
+}
+
+func (p *cardRateProvider) Name() string {
+	return p.official.Name()
+}
+
+func (p *cardRateProvider) FetchRate(ctx context.Context) (*Quote, error) {
+	official, err := p.official.FetchRate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quote{
+		Type:     database.RateType_Card,
+		Provider: p.Name(),
+		Rate:     roundRate(official.Rate * (1 + p.surcharge)),
+		Components: map[string]float64{
+			"official":  official.Rate,
+			"surcharge": p.surcharge,
+		},
+		FetchedAt: official.FetchedAt,
+	}, nil
+}
@@ -0,0 +1,109 @@
+package dollar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+// Stand-in of DolarApi that answers every request with status and body
+func newDolarApiServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestOfficialProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		rate    float64
+		wantErr bool
+	}{
+		{name: "selling price", status: http.StatusOK, body: `{"compra": 1015.5, "venta": 1055.499}`, rate: 1055.5},
+		{name: "zero selling price", status: http.StatusOK, body: `{"compra": 1015.5, "venta": 0}`, wantErr: true},
+		{name: "error status", status: http.StatusServiceUnavailable, body: `{}`, wantErr: true},
+		{name: "invalid body", status: http.StatusOK, body: `not json`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newDolarApiServer(t, tt.status, tt.body)
+			provider := NewOfficialProvider(server.Client(), server.URL)
+
+			quote, err := provider.FetchRate(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got rate %v", quote.Rate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if quote.Type != database.RateType_Official {
+				t.Errorf("type = %s, want %s", quote.Type, database.RateType_Official)
+			}
+			if quote.Rate != tt.rate {
+				t.Errorf("rate = %v, want %v", quote.Rate, tt.rate)
+			}
+		})
+	}
+}
+
+func TestCardProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		venta     float64
+		surcharge float64
+		rate      float64
+	}{
+		{name: "default surcharge", venta: 1000, surcharge: 0.3, rate: 1300},
+		{name: "rounded to cents", venta: 1055.55, surcharge: 0.3, rate: 1372.22},
+		{name: "no surcharge", venta: 1055.55, surcharge: 0, rate: 1055.55},
+		{name: "higher surcharge", venta: 1000, surcharge: 0.6, rate: 1600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newDolarApiServer(t, http.StatusOK, fmt.Sprintf(`{"compra": 1, "venta": %v}`, tt.venta))
+			provider := NewCardProvider(NewOfficialProvider(server.Client(), server.URL), tt.surcharge)
+
+			quote, err := provider.FetchRate(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if quote.Type != database.RateType_Card {
+				t.Errorf("type = %s, want %s", quote.Type, database.RateType_Card)
+			}
+			if quote.Rate != tt.rate {
+				t.Errorf("rate = %v, want %v", quote.Rate, tt.rate)
+			}
+			if quote.Components["official"] != tt.venta || quote.Components["surcharge"] != tt.surcharge {
+				t.Errorf("components = %v, want official %v and surcharge %v", quote.Components, tt.venta, tt.surcharge)
+			}
+		})
+	}
+}
+
+func TestCardProviderOfficialError(t *testing.T) {
+	server := newDolarApiServer(t, http.StatusInternalServerError, `{}`)
+	provider := NewCardProvider(NewOfficialProvider(server.Client(), server.URL), 0.3)
+
+	if _, err := provider.FetchRate(context.Background()); err == nil {
+		t.Fatal("expected the error of the official rate")
+	}
+}
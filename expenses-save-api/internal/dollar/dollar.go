@@ -1,181 +1,389 @@
 package dollar
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	stdErrors "errors"
 	"fmt"
 	"log"
-	"math"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/jackc/pgx/v5"
 )
 
 type DollarService struct {
-	client     *http.Client
-	cache      *exchangeRateCache
-	apiBaseURL string
+	providers        map[database.RateType]RateProvider
+	caches           map[database.RateType]*exchangeRateCache
+	currencyRates    *CurrencyRateClient
+	exchangeRateRepo *repository.ExchangeRateRepository
+	currencyRateRepo *repository.CurrencyRateRepository
 }
 
 type exchangeRateCache struct {
-	rate      float64
-	timestamp time.Time
-	mutex     sync.RWMutex
+	quote *Quote
+	// The quote was loaded from the database or the last fetch failed
+	stale bool
+	// Last failed fetch, the cached quote is not fetched again until retryInterval passes
+	failedAt time.Time
+	// A request started a fetch in the background, see GetQuote
+	refreshing bool
+	mutex      sync.Mutex
 }
 
-func NewDollarService() (*DollarService, error) {
+// Time to wait before fetching a rate again after its providers failed
+const retryInterval = time.Minute
+
+/*
+The API does not need to be reachable at startup. The caches start with the
+last stored rates, which are served as stale until a provider answers.
+*/
+func NewDollarService(exchangeRateRepo *repository.ExchangeRateRepository, currencyRateRepo *repository.CurrencyRateRepository) *DollarService {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	prices := NewBondPriceClient(client, *env.STOCK_MARKET_API_URL)
+	official := NewFallbackProvider(NewOfficialProvider(client, *env.OFFICIAL_RATE_API_URL), NewDolarApiProvider(database.RateType_Official, client, *env.DOLAR_API_URL+"/oficial"))
+
+	service := NewDollarServiceWithProviders(
+		exchangeRateRepo,
+		currencyRateRepo,
+		NewCurrencyRateClient(client, *env.CURRENCY_RATE_API_URL),
+		NewFallbackProvider(NewMEPProvider(prices), NewDolarApiProvider(database.RateType_MEP, client, *env.DOLAR_API_URL+"/bolsa")),
+		NewFallbackProvider(NewCCLProvider(prices), NewDolarApiProvider(database.RateType_CCL, client, *env.DOLAR_API_URL+"/contadoconliqui")),
+		official,
+		NewFallbackProvider(NewCardProvider(official, *env.CARD_RATE_SURCHARGE), NewDolarApiProvider(database.RateType_Card, client, *env.DOLAR_API_URL+"/tarjeta")),
+	)
+
+	service.loadStoredRates(context.Background())
+
+	return service
+}
+
+// A provider per rate type, later ones replace earlier ones of the same type
+func NewDollarServiceWithProviders(exchangeRateRepo *repository.ExchangeRateRepository, currencyRateRepo *repository.CurrencyRateRepository, currencyRates *CurrencyRateClient, providers ...RateProvider) *DollarService {
 	service := &DollarService{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-		cache:      &exchangeRateCache{},
-		apiBaseURL: *env.STOCK_MARKET_API_URL,
+		providers:        make(map[database.RateType]RateProvider),
+		caches:           make(map[database.RateType]*exchangeRateCache),
+		currencyRates:    currencyRates,
+		exchangeRateRepo: exchangeRateRepo,
+		currencyRateRepo: currencyRateRepo,
 	}
 
-	err := service.UpdateRate()
+	for _, provider := range providers {
+		service.providers[provider.Type()] = provider
+		service.caches[provider.Type()] = &exchangeRateCache{}
+	}
 
-	if err != nil {
-		return nil, err
+	return service
+}
+
+/*
+Cached quote of the rate type. The RateCollector keeps it fresh, so requests
+do not wait for a provider: once EXCHANGE_RATE_TTL expires the cached quote is
+still returned while it is fetched again in the background. If the providers
+fail, the last known rate is returned with Stale set. Only waits for a
+provider when the rate was never fetched nor stored, and only fails if that
+fetch fails too.
+*/
+func (s *DollarService) GetQuote(ctx context.Context, rateType database.RateType) (*Quote, error) {
+	cache, ok := s.caches[rateType]
+	if !ok {
+		return nil, fmt.Errorf("no provider for rate type %s", rateType)
+	}
+
+	if quote := s.cachedQuote(rateType, cache, true); quote != nil {
+		return quote, nil
 	}
 
-	return service, nil
+	s.loadStoredRate(ctx, rateType, cache)
+	if quote := s.cachedQuote(rateType, cache, true); quote != nil {
+		return quote, nil
+	}
+
+	return s.updateRate(ctx, rateType, cache)
 }
 
-func (s *DollarService) GetExchangeRate() (float64, error) {
-	s.cache.mutex.RLock()
+/*
+Cached quote of the rate type, or the last stored one if it was not fetched
+yet. Unlike GetQuote it never calls a provider, not even in the background,
+so reading the rates does not refresh them.
+*/
+func (s *DollarService) CachedQuote(ctx context.Context, rateType database.RateType) (*Quote, error) {
+	cache, ok := s.caches[rateType]
+	if !ok {
+		return nil, fmt.Errorf("no provider for rate type %s", rateType)
+	}
 
-	if !s.isCacheExpired() {
-		rate := s.cache.rate
-		s.cache.mutex.RUnlock()
-		return rate, nil
+	if quote := s.cachedQuote(rateType, cache, false); quote != nil {
+		return quote, nil
 	}
 
-	s.cache.mutex.RUnlock()
+	s.loadStoredRate(ctx, rateType, cache)
+	if quote := s.cachedQuote(rateType, cache, false); quote != nil {
+		return quote, nil
+	}
 
-	s.cache.mutex.Lock()
-	defer s.cache.mutex.Unlock()
+	return nil, fmt.Errorf("no %s exchange rate was fetched yet", rateType)
+}
 
-	// Double check if cache was updated while waiting for lock
-	if !s.isCacheExpired() {
-		return s.cache.rate, nil
+// Fetches the rate even if the cached one did not expire
+func (s *DollarService) UpdateRate(ctx context.Context, rateType database.RateType) (*Quote, error) {
+	cache, ok := s.caches[rateType]
+	if !ok {
+		return nil, fmt.Errorf("no provider for rate type %s", rateType)
 	}
 
-	err := s.UpdateRate()
+	return s.updateRate(ctx, rateType, cache)
+}
 
-	if err != nil {
-		return 0, err
+/*
+Returns the cached quote, or nil if there is none. With refresh, an expired
+quote is fetched again in the background unless that is already happening
+or the last fetch failed less than retryInterval ago.
+*/
+func (s *DollarService) cachedQuote(rateType database.RateType, cache *exchangeRateCache, refresh bool) *Quote {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.quote == nil {
+		return nil
 	}
 
-	return s.cache.rate, nil
+	if refresh && cache.isExpired() && !cache.refreshing && !cache.isRetrying() {
+		cache.refreshing = true
+		go s.refresh(rateType, cache)
+	}
+
+	if cache.stale {
+		return staleQuote(cache.quote)
+	}
+	return cache.quote
 }
 
-func (s *DollarService) UpdateRate() error {
-	rate, err := s.getBondExchangeRate()
+func (s *DollarService) refresh(rateType database.RateType, cache *exchangeRateCache) {
+	if _, err := s.updateRate(context.Background(), rateType, cache); err != nil {
+		log.Printf("using last known %s exchange rate: %v", rateType, err)
+	}
+
+	cache.mutex.Lock()
+	cache.refreshing = false
+	cache.mutex.Unlock()
+}
+
+/*
+Fetches the rate and swaps it into the cache. The lock is only taken once the
+provider answered, so a slow provider does not block the requests reading the
+cached quote.
+*/
+func (s *DollarService) updateRate(ctx context.Context, rateType database.RateType, cache *exchangeRateCache) (*Quote, error) {
+	quote, err := s.providers[rateType].FetchRate(ctx)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
 	if err != nil {
-		return fmt.Errorf("failed to fetch exchange rate: %v", err)
+		cache.failedAt = time.Now()
+		cache.stale = cache.quote != nil
+		return nil, fmt.Errorf("failed to fetch %s exchange rate: %v", rateType, err)
 	}
 
-	s.cache.rate = rate
-	s.cache.timestamp = time.Now()
-	log.Printf("exchange rate updated. USD/ARS: %f", rate)
+	// A fetch that started earlier but answered later does not replace a newer quote
+	if cache.quote == nil || cache.stale || !quote.FetchedAt.Before(cache.quote.FetchedAt) {
+		cache.quote = quote
+	}
+	cache.stale = false
+	cache.failedAt = time.Time{}
+	log.Printf("exchange rate updated. %s USD/ARS: %f", rateType, quote.Rate)
 
-	return nil
+	return quote, nil
 }
 
-func (s *DollarService) isCacheExpired() bool {
-	if s.cache.rate == 0 {
-		return true
+/*
+Quote of the rate type on the calendar day of date, in the location of date.
+Today and later days use the current rate and previous days the one stored
+by the RateCollector. A past day without a stored rate close enough is a
+ValidationError, like in currencyRateAt.
+*/
+func (s *DollarService) GetQuoteAt(ctx context.Context, rateType database.RateType, date time.Time) (*Quote, error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if !day.Before(rateDay(time.Now())) {
+		return s.GetQuote(ctx, rateType)
+	}
+
+	rate, err := s.exchangeRateRepo.GetAtDate(ctx, rateType, day)
+	if err != nil && !stdErrors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get %s exchange rate of %s: %w", rateType, day.Format(dates.DateLayout), err)
+	}
+
+	if err != nil || !RateCoversDay(rate.Date, day) {
+		return nil, &errors.ValidationError{
+			Field:   "date",
+			Message: fmt.Sprintf("no stored %s rate for %s", rateType, day.Format(dates.DateLayout)),
+			Code:    int32(errors.InvalidDate),
+		}
 	}
 
-	return time.Since(s.cache.timestamp) > time.Duration(*env.EXCHANGE_RATE_TTL)*time.Minute
+	return quoteFromRate(rate), nil
 }
 
-func (s *DollarService) getBondPrice(ticker string) (float64, error) {
-	type bondRequest struct {
-		Market    string `json:"market"`
-		Ticker    string `json:"ticker"`
-		AssetType string `json:"assetType"`
+/*
+Units of currency per USD on the calendar day of date, in the location of
+date. Today and later days use the current rate and previous days the one
+stored by the RateCollector. A past day without a stored rate close enough is
+a ValidationError, its current rate could be far from the one of the day.
+*/
+func (s *DollarService) currencyRateAt(ctx context.Context, currency string, date time.Time) (float64, error) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if !day.Before(rateDay(time.Now())) {
+		return s.currencyRates.GetRate(ctx, currency)
 	}
 
-	type bondResponse struct {
-		Value               float64 `json:"value"`
-		Change              float64 `json:"change"`
-		ChangePct           float64 `json:"changePct"`
-		Ticker              string  `json:"ticker"`
-		Market              string  `json:"market"`
-		AssetType           *string `json:"assetType"`
-		UnitsForTickerPrice int     `json:"unitsForTickerPrice"`
-		Currency            string  `json:"currency"`
-		Source              string  `json:"source"`
+	rate, err := s.currencyRateRepo.GetAtDate(ctx, currency, day)
+	if err != nil && !stdErrors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to get %s rate of %s: %w", currency, day.Format(dates.DateLayout), err)
 	}
 
-	payload := bondRequest{
-		Market:    "BCBA",
-		Ticker:    ticker,
-		AssetType: "BOND",
+	if err != nil || !RateCoversDay(rate.Date, day) {
+		return 0, &errors.ValidationError{
+			Field:   "date",
+			Message: fmt.Sprintf("no stored %s rate for %s", currency, day.Format(dates.DateLayout)),
+			Code:    int32(errors.InvalidCurrency),
+		}
 	}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return 0, fmt.Errorf("failed to marshal %s request payload: %v", ticker, err)
+	return rate.Rate, nil
+}
+
+// Days a stored rate can be older than the day it is used for, so weekends and holidays have one
+const MaxRateGapDays = 4
+
+// Reports whether the rate stored for rateDay can be used on day. Both are UTC midnights
+func RateCoversDay(rateDay time.Time, day time.Time) bool {
+	gap := day.Sub(rateDay)
+	return gap >= 0 && gap <= MaxRateGapDays*24*time.Hour
+}
+
+func (s *DollarService) loadStoredRates(ctx context.Context) {
+	for rateType, cache := range s.caches {
+		s.loadStoredRate(ctx, rateType, cache)
 	}
+}
 
-	resp, err := s.client.Post(s.apiBaseURL, "application/json", bytes.NewReader(jsonData))
+// Caches the last stored rate, served as stale, unless there is a quote already
+func (s *DollarService) loadStoredRate(ctx context.Context, rateType database.RateType, cache *exchangeRateCache) {
+	rate, err := s.exchangeRateRepo.GetLatest(ctx, rateType)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch %s price: %v", ticker, err)
+		if !stdErrors.Is(err, pgx.ErrNoRows) {
+			log.Printf("failed to load stored %s exchange rate: %v", rateType, err)
+		}
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("API returned non-200 status code for %s: %d", ticker, resp.StatusCode)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.quote == nil {
+		cache.quote = quoteFromRate(rate)
+		cache.stale = true
 	}
+}
 
-	var bondResp bondResponse
-	if err := json.NewDecoder(resp.Body).Decode(&bondResp); err != nil {
-		return 0, fmt.Errorf("failed to decode %s response: %v", ticker, err)
+func quoteFromRate(rate *database.ExchangeRate) *Quote {
+	return &Quote{
+		Type:       rate.RateType,
+		Provider:   rate.Provider,
+		Rate:       rate.Rate,
+		Components: rate.Components,
+		FetchedAt:  rate.FetchedAt,
 	}
+}
 
-	return bondResp.Value, nil
+func staleQuote(quote *Quote) *Quote {
+	stale := *quote
+	stale.Stale = true
+	return &stale
 }
 
-func (s *DollarService) getBondExchangeRate() (float64, error) {
-	type result struct {
-		price float64
-		err   error
+/*
+Converts an amount paid in currency to ARS and USD. USD/ARS uses the rate type
+on the day of date (see GetQuoteAt). Currencies other than ARS and USD are
+converted to USD with their rate against it on that day (see currencyRateAt).
+*/
+func (s *DollarService) Convert(ctx context.Context, currency string, amount money.Money, rateType database.RateType, date time.Time) (*database.ExpenseAmount, error) {
+	quote, err := s.GetQuoteAt(ctx, rateType, date)
+	if err != nil {
+		return nil, err
 	}
 
-	al30Chan := make(chan result)
-	al30dChan := make(chan result)
+	// Copies, the quote may be cached
+	rateType, provider, fetchedAt := quote.Type, quote.Provider, quote.FetchedAt
 
-	go func() {
-		price, err := s.getBondPrice("AL30")
-		al30Chan <- result{price: price, err: err}
-	}()
-
-	go func() {
-		price, err := s.getBondPrice("AL30D")
-		al30dChan <- result{price: price, err: err}
-	}()
+	converted := &database.ExpenseAmount{
+		Currency:       currency,
+		OriginalAmount: amount,
+		UsdArsRate:     quote.Rate,
+		RateType:       &rateType,
+		RateProvider:   &provider,
+		RateFetchedAt:  &fetchedAt,
+	}
 
-	al30Result := <-al30Chan
-	if al30Result.err != nil {
-		return 0, al30Result.err
+	switch currency {
+	case "ARS":
+		converted.CurrencyUsdRate = quote.Rate
+	case "USD":
+		converted.CurrencyUsdRate = 1
+	default:
+		converted.CurrencyUsdRate, err = s.currencyRateAt(ctx, currency, date)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	al30dResult := <-al30dChan
-	if al30dResult.err != nil {
-		return 0, al30dResult.err
+	// Both from amount, so the ARS one is not rounded twice
+	converted.USDAmount = amount.Div(converted.CurrencyUsdRate)
+	converted.ARSAmount = amount.Mul(converted.UsdArsRate / converted.CurrencyUsdRate)
+
+	// The amount that was actually paid is kept as is
+	switch currency {
+	case "ARS":
+		converted.ARSAmount = amount
+	case "USD":
+		converted.USDAmount = amount
 	}
 
-	if al30dResult.price == 0 {
-		return 0, fmt.Errorf("AL30D price cannot be zero")
+	return converted, nil
+}
+
+// Rate types that have a provider, sorted
+func (s *DollarService) RateTypes() []database.RateType {
+	rateTypes := make([]database.RateType, 0, len(s.providers))
+	for rateType := range s.providers {
+		rateTypes = append(rateTypes, rateType)
 	}
 
-	exchangeRate := al30Result.price / al30dResult.price
+	sort.Slice(rateTypes, func(i, j int) bool { return rateTypes[i] < rateTypes[j] })
+	return rateTypes
+}
 
-	roundedRate := math.Round(exchangeRate*100) / 100
+// cache has to be locked
+func (c *exchangeRateCache) isExpired() bool {
+	if c.quote == nil || c.quote.Rate == 0 {
+		return true
+	}
+
+	return time.Since(c.quote.FetchedAt) > time.Duration(*env.EXCHANGE_RATE_TTL)*time.Minute
+}
 
-	return roundedRate, nil
+// The last fetch failed recently and there is a quote to serve meanwhile. cache has to be locked
+func (c *exchangeRateCache) isRetrying() bool {
+	return c.quote != nil && time.Since(c.failedAt) < retryInterval
 }
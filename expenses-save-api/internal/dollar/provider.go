@@ -0,0 +1,80 @@
+package dollar
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+)
+
+/*
+Source of a USD/ARS exchange rate. Providers receive the URL and HTTP client
+they use, so they can be pointed to a local stand-in of the API.
+*/
+type RateProvider interface {
+	Type() database.RateType
+	// Identifies where the rate comes from, e.g. the API it reads
+	Name() string
+	FetchRate(ctx context.Context) (*Quote, error)
+}
+
+// ARS per USD of a rate type
+type Quote struct {
+	Type     database.RateType
+	Provider string
+	Rate     float64
+	// Prices the rate was computed from, by name (e.g. AL30 and AL30D)
+	Components map[string]float64
+	FetchedAt  time.Time
+	// The providers failed and this is the last known rate
+	Stale bool
+}
+
+/*
+Tries the providers in order until one of them returns a rate. All of them
+have to be of the same rate type.
+*/
+type fallbackProvider struct {
+	providers []RateProvider
+}
+
+func NewFallbackProvider(providers ...RateProvider) RateProvider {
+	if len(providers) == 1 {
+		return providers[0]
+	}
+	return &fallbackProvider{providers: providers}
+}
+
+func (p *fallbackProvider) Type() database.RateType {
+	return p.providers[0].Type()
+}
+
+// Name of the first provider, the quotes have the name of the one that answered
+func (p *fallbackProvider) Name() string {
+	return p.providers[0].Name()
+}
+
+func (p *fallbackProvider) FetchRate(ctx context.Context) (*Quote, error) {
+	var errs []error
+
+	for _, provider := range p.providers {
+		quote, err := provider.FetchRate(ctx)
+		if err == nil {
+			if len(errs) > 0 {
+				log.Printf("%s exchange rate fetched from fallback provider %s", p.Type(), provider.Name())
+			}
+			return quote, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func roundRate(rate float64) float64 {
+	return math.Round(rate*100) / 100
+}
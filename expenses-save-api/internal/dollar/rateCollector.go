@@ -0,0 +1,128 @@
+package dollar
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+)
+
+/*
+RateCollector refreshes the rate of every rate type every EXCHANGE_RATE_TTL
+minutes, so requests rarely wait for a provider, and stores it in
+exchange_rate, so past expenses can be converted with the rate of their day.
+The rates of the other currencies are stored in currency_rate the same way.
+The row of the day is overwritten and ends up with the last rate of the day.
+*/
+type RateCollector struct {
+	dollarService    *DollarService
+	exchangeRateRepo *repository.ExchangeRateRepository
+	currencyRateRepo *repository.CurrencyRateRepository
+	currencyRepo     *repository.CurrencyRepository
+}
+
+func NewRateCollector(dollarService *DollarService, exchangeRateRepo *repository.ExchangeRateRepository, currencyRateRepo *repository.CurrencyRateRepository, currencyRepo *repository.CurrencyRepository) *RateCollector {
+	return &RateCollector{
+		dollarService:    dollarService,
+		exchangeRateRepo: exchangeRateRepo,
+		currencyRateRepo: currencyRateRepo,
+		currencyRepo:     currencyRepo,
+	}
+}
+
+func (c *RateCollector) Start(ctx context.Context) {
+	interval := time.Duration(*env.EXCHANGE_RATE_TTL) * time.Minute
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.collect(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// A failing rate type does not stop the others
+func (c *RateCollector) collect(ctx context.Context) {
+	for _, rateType := range c.dollarService.RateTypes() {
+		quote, err := c.dollarService.UpdateRate(ctx, rateType)
+		if err != nil {
+			log.Printf("failed to collect exchange rate: %v", err)
+			continue
+		}
+
+		err = c.exchangeRateRepo.Upsert(ctx, &database.ExchangeRate{
+			RateType:   quote.Type,
+			Date:       rateDay(quote.FetchedAt),
+			Rate:       quote.Rate,
+			Provider:   quote.Provider,
+			Components: quote.Components,
+			FetchedAt:  quote.FetchedAt,
+		})
+		if err != nil {
+			log.Printf("failed to store %s exchange rate: %v", rateType, err)
+		}
+	}
+
+	c.collectCurrencies(ctx)
+}
+
+// Rates the API did not refresh today are not stored, they would be taken as the rate of the day
+func (c *RateCollector) collectCurrencies(ctx context.Context) {
+	currencies, err := c.currencyRepo.GetAll(ctx)
+	if err != nil {
+		log.Printf("failed to get currencies: %v", err)
+		return
+	}
+
+	rates, fetchedAt, err := c.dollarService.currencyRates.GetRates(ctx)
+	if err != nil {
+		log.Printf("failed to collect currency rates: %v", err)
+		return
+	}
+
+	if !rateDay(fetchedAt).Equal(rateDay(time.Now())) {
+		log.Printf("currency rates were last fetched at %s, not storing them", fetchedAt.Format(time.RFC3339))
+		return
+	}
+
+	for _, currency := range currencies {
+		if currency.Code == "ARS" || currency.Code == "USD" {
+			continue
+		}
+
+		rate, ok := rates[currency.Code]
+		if !ok || rate == 0 {
+			log.Printf("no USD rate for currency %s", currency.Code)
+			continue
+		}
+
+		err := c.currencyRateRepo.Upsert(ctx, &database.CurrencyRate{
+			Currency:  currency.Code,
+			Date:      rateDay(fetchedAt),
+			Rate:      rate,
+			FetchedAt: fetchedAt,
+		})
+		if err != nil {
+			log.Printf("failed to store %s rate: %v", currency.Code, err)
+		}
+	}
+}
+
+// Stored rates are by Buenos Aires day, where the market is
+func rateDay(t time.Time) time.Time {
+	local := dates.In(t, dates.DefaultTimezone)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
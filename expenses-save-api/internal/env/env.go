@@ -10,12 +10,21 @@ import (
 )
 
 var (
-	GRPC_PORT            *string
-	CREDENTIALS_BASE64   *string
-	DB_URL               *string
-	STOCK_MARKET_API_URL *string
-	EXCHANGE_RATE_TTL    *int8 // in minutes
-	HTTP_PORT            *string
+	GRPC_PORT              *string
+	CREDENTIALS_BASE64     *string
+	DB_URL                 *string
+	STOCK_MARKET_API_URL   *string
+	OFFICIAL_RATE_API_URL  *string
+	DOLAR_API_URL          *string  // base URL of the fallback rates, e.g. DOLAR_API_URL/bolsa
+	CARD_RATE_SURCHARGE    *float64 // fraction added to the official rate, e.g. 0.3
+	CURRENCY_RATE_API_URL  *string  // rates of other currencies against USD
+	EXCHANGE_RATE_TTL      *int8    // in minutes
+	HTTP_PORT              *string
+	GRPC_LEGACY_REPLY      *bool   // return AddExpense errors inside ExpenseReply
+	GRPC_CLIENT_SECRET     *string // only required by the server, not by the subcommands
+	KAFKA_BROKERS          *string // comma separated, the consumer is disabled if empty
+	KAFKA_GROUP_ID         *string
+	KAFKA_DEFAULT_CATEGORY *string // used when no category rule matches a notification
 )
 
 func LoadEnv() {
@@ -29,8 +38,17 @@ func LoadEnv() {
 	loadStr(&CREDENTIALS_BASE64, "CREDENTIALS_BASE64")
 	loadStr(&DB_URL, "DB_URL")
 	loadStr(&STOCK_MARKET_API_URL, "STOCK_MARKET_API_URL")
+	loadOptionalStr(&OFFICIAL_RATE_API_URL, "OFFICIAL_RATE_API_URL", "https://dolarapi.com/v1/dolares/oficial")
+	loadOptionalStr(&DOLAR_API_URL, "DOLAR_API_URL", "https://dolarapi.com/v1/dolares")
+	loadOptionalFloat(&CARD_RATE_SURCHARGE, "CARD_RATE_SURCHARGE", 0.3)
+	loadOptionalStr(&CURRENCY_RATE_API_URL, "CURRENCY_RATE_API_URL", "https://open.er-api.com/v6/latest/USD")
 	loadInt8(&EXCHANGE_RATE_TTL, "EXCHANGE_RATE_TTL")
 	loadStr(&HTTP_PORT, "HTTP_PORT")
+	loadOptionalBool(&GRPC_LEGACY_REPLY, "GRPC_LEGACY_REPLY", false)
+	loadOptionalStr(&GRPC_CLIENT_SECRET, "GRPC_CLIENT_SECRET", "")
+	loadOptionalStr(&KAFKA_BROKERS, "KAFKA_BROKERS", "")
+	loadOptionalStr(&KAFKA_GROUP_ID, "KAFKA_GROUP_ID", "expenses-save-api")
+	loadOptionalStr(&KAFKA_DEFAULT_CATEGORY, "KAFKA_DEFAULT_CATEGORY", "")
 }
 
 func setPort() {
@@ -61,6 +79,17 @@ func loadStr(dest **string, varName string) error {
 	return nil
 }
 
+func loadOptionalStr(dest **string, varName string, defaultValue string) error {
+	p := os.Getenv(varName)
+
+	if len(p) == 0 {
+		p = defaultValue
+	}
+
+	*dest = &p
+	return nil
+}
+
 func loadInt8(dest **int8, varName string) error {
 	p := os.Getenv(varName)
 
@@ -77,3 +106,37 @@ func loadInt8(dest **int8, varName string) error {
 	*dest = &val
 	return nil
 }
+
+func loadOptionalBool(dest **bool, varName string, defaultValue bool) error {
+	p := os.Getenv(varName)
+
+	if len(p) == 0 {
+		*dest = &defaultValue
+		return nil
+	}
+
+	val, err := strconv.ParseBool(p)
+	if err != nil {
+		log.Fatalf("environment variable %s is not a valid bool: %v", varName, err)
+	}
+
+	*dest = &val
+	return nil
+}
+
+func loadOptionalFloat(dest **float64, varName string, defaultValue float64) error {
+	p := os.Getenv(varName)
+
+	if len(p) == 0 {
+		*dest = &defaultValue
+		return nil
+	}
+
+	val, err := strconv.ParseFloat(p, 64)
+	if err != nil {
+		log.Fatalf("environment variable %s is not a valid float: %v", varName, err)
+	}
+
+	*dest = &val
+	return nil
+}
@@ -1,16 +1,22 @@
 package errors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ValidationError struct {
 	Field   string
 	Message string
 	Code    int32
+	// Names the client can offer instead of the one sent, the most likely first
+	Suggestions []string
 }
 
 func (e *ValidationError) Error() string {
@@ -28,8 +34,79 @@ const (
 	InvalidSubcategory
 	InvalidDate
 	InvalidCurrency
+	InvalidRecurrentExpense
+	InvalidInstallments
 )
 
+var responseCodeNames = map[ResponseCode]string{
+	Success:                 "SUCCESS",
+	InternalError:           "INTERNAL_ERROR",
+	InvalidPayload:          "INVALID_PAYLOAD",
+	InvalidPaymentMethod:    "INVALID_PAYMENT_METHOD",
+	InvalidCategory:         "INVALID_CATEGORY",
+	InvalidSubcategory:      "INVALID_SUBCATEGORY",
+	InvalidDate:             "INVALID_DATE",
+	InvalidCurrency:         "INVALID_CURRENCY",
+	InvalidRecurrentExpense: "INVALID_RECURRENT_EXPENSE",
+	InvalidInstallments:     "INVALID_INSTALLMENTS",
+}
+
+func (c ResponseCode) String() string {
+	if name, ok := responseCodeNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("RESPONSE_CODE_%d", int32(c))
+}
+
+// Domain used in the ErrorInfo details of gRPC errors
+const errorDomain = "expenses-save-api"
+
+/*
+Builds a gRPC status error. Validation errors are returned as InvalidArgument,
+or NotFound when they reference something the user does not have, with the
+offending field in a BadRequest detail and the ResponseCode in an ErrorInfo
+detail. Any other error is Internal.
+*/
+func StatusFromError(err error) error {
+	var validationErr *ValidationError
+
+	if !errors.As(err, &validationErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code := codes.InvalidArgument
+	switch ResponseCode(validationErr.Code) {
+	case InvalidPaymentMethod, InvalidCategory, InvalidSubcategory, InvalidRecurrentExpense:
+		code = codes.NotFound
+	}
+
+	metadata := map[string]string{"code": fmt.Sprint(validationErr.Code)}
+	if len(validationErr.Suggestions) > 0 {
+		// JSON array, names may contain commas
+		suggestions, _ := json.Marshal(validationErr.Suggestions)
+		metadata["suggestions"] = string(suggestions)
+	}
+
+	st, detailsErr := status.New(code, validationErr.Message).WithDetails(
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: validationErr.Field, Description: validationErr.Message},
+			},
+		},
+		&errdetails.ErrorInfo{
+			Reason:   ResponseCode(validationErr.Code).String(),
+			Domain:   errorDomain,
+			Metadata: metadata,
+		},
+	)
+	if detailsErr != nil {
+		return status.Error(code, validationErr.Message)
+	}
+
+	return st.Err()
+}
+
 func ReplyFromError(err error) *proto.ExpenseReply {
 	var validationErr *ValidationError
 
@@ -0,0 +1,266 @@
+package expensesync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/destination"
+	"github.com/google/uuid"
+)
+
+const (
+	pollInterval = 30 * time.Second
+	batchSize    = 100
+	claimLease   = 5 * time.Minute
+	maxAttempts  = 10
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+)
+
+/*
+ExpenseSyncWorker delivers the pending rows of the expense_sync outbox
+to their destinations through the destination registry, one row per
+expense and destination so each one is tracked separately. Rows are
+written in the same transaction as the expense, so an expense is never
+lost even if the destination is down.
+
+Inserts and updates claimed together for a destination that implements
+BatchDestination are sent in one go. If that fails they are retried one by
+one, so a single bad expense does not hold back the rest.
+*/
+type ExpenseSyncWorker struct {
+	syncRepo        *repository.ExpenseSyncRepository
+	destinationRepo *repository.UserExpenseSaveRepository
+	expenseRepo     *repository.ExpenseRepository
+	registry        *destination.Registry
+	notify          chan struct{}
+}
+
+func NewExpenseSyncWorker(
+	syncRepo *repository.ExpenseSyncRepository,
+	destinationRepo *repository.UserExpenseSaveRepository,
+	expenseRepo *repository.ExpenseRepository,
+	registry *destination.Registry,
+) *ExpenseSyncWorker {
+	return &ExpenseSyncWorker{
+		syncRepo:        syncRepo,
+		destinationRepo: destinationRepo,
+		expenseRepo:     expenseRepo,
+		registry:        registry,
+		notify:          make(chan struct{}, 1),
+	}
+}
+
+func (w *ExpenseSyncWorker) Start(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.processPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.notify:
+		}
+	}
+}
+
+// Wakes up the worker without waiting for the next poll
+func (w *ExpenseSyncWorker) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *ExpenseSyncWorker) processPending(ctx context.Context) {
+	for {
+		syncs, err := w.syncRepo.ClaimPending(ctx, batchSize, claimLease)
+		if err != nil {
+			log.Printf("failed to claim pending expense syncs: %v", err)
+			return
+		}
+
+		w.processClaimed(ctx, syncs)
+
+		if len(syncs) < batchSize {
+			return
+		}
+	}
+}
+
+func (w *ExpenseSyncWorker) processClaimed(ctx context.Context, syncs []database.ExpenseSync) {
+	byDestination := make(map[uuid.UUID][]*database.ExpenseSync)
+	var single []*database.ExpenseSync
+	var deletes []*database.ExpenseSync
+
+	for i := range syncs {
+		sync := &syncs[i]
+		if sync.Operation == database.SyncOperation_Delete {
+			deletes = append(deletes, sync)
+			continue
+		}
+		byDestination[sync.DestinationID] = append(byDestination[sync.DestinationID], sync)
+	}
+
+	for destinationID, group := range byDestination {
+		if len(group) == 1 {
+			single = append(single, group...)
+			continue
+		}
+
+		delivered, err := w.deliverMany(ctx, destinationID, group)
+		if err != nil {
+			log.Printf("failed to sync %d expenses to destination %s together, syncing them one by one: %v", len(group), destinationID, err)
+			single = append(single, group...)
+			continue
+		}
+
+		for _, sync := range group {
+			if !delivered[sync.ID] {
+				single = append(single, sync)
+				continue
+			}
+
+			if err := w.syncRepo.MarkDone(ctx, sync.ID); err != nil {
+				log.Printf("failed to mark expense sync %s as done: %v", sync.ID, err)
+			}
+		}
+	}
+
+	for _, sync := range single {
+		w.process(ctx, sync)
+	}
+
+	// Deletes go last, so an expense inserted and deleted in the same batch ends up deleted
+	for _, sync := range deletes {
+		w.process(ctx, sync)
+	}
+}
+
+/*
+Upserts the current state of the expenses of the syncs with a single call
+to the destination. Returns the IDs of the syncs that were delivered, which
+are none if the destination does not support batches. Expenses that no
+longer exist are left for the one by one delivery, which removes them.
+*/
+func (w *ExpenseSyncWorker) deliverMany(ctx context.Context, destinationID uuid.UUID, syncs []*database.ExpenseSync) (map[uuid.UUID]bool, error) {
+	saveDestination, err := w.destinationRepo.GetByID(ctx, destinationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination: %w", err)
+	}
+
+	impl, err := w.registry.Get(saveDestination.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	batchImpl, ok := impl.(destination.BatchDestination)
+	if !ok {
+		return nil, nil
+	}
+
+	expenseIDs := make([]uuid.UUID, 0, len(syncs))
+	for _, sync := range syncs {
+		expenseIDs = append(expenseIDs, sync.ExpenseID)
+	}
+
+	rows, err := w.expenseRepo.GetSheetsRowsByIDs(ctx, expenseIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve expenses: %w", err)
+	}
+
+	expenses := make([]*database.ExpenseSheetsRow, 0, len(rows))
+	found := make(map[uuid.UUID]bool, len(rows))
+	for i := range rows {
+		expenses = append(expenses, &rows[i])
+		found[rows[i].ID] = true
+	}
+
+	if len(expenses) > 0 {
+		if err := batchImpl.UpsertMany(ctx, saveDestination, expenses); err != nil {
+			return nil, err
+		}
+	}
+
+	delivered := make(map[uuid.UUID]bool, len(syncs))
+	for _, sync := range syncs {
+		if found[sync.ExpenseID] {
+			delivered[sync.ID] = true
+		}
+	}
+
+	return delivered, nil
+}
+
+func (w *ExpenseSyncWorker) process(ctx context.Context, sync *database.ExpenseSync) {
+	err := w.deliver(ctx, sync)
+
+	if err == nil {
+		if err := w.syncRepo.MarkDone(ctx, sync.ID); err != nil {
+			log.Printf("failed to mark expense sync %s as done: %v", sync.ID, err)
+		}
+		return
+	}
+
+	log.Printf("failed to sync expense %s to destination %s (attempt %d): %v", sync.ExpenseID, sync.DestinationID, sync.Attempts, err)
+
+	if sync.Attempts >= maxAttempts {
+		if err := w.syncRepo.MarkFailed(ctx, sync.ID, err.Error()); err != nil {
+			log.Printf("failed to mark expense sync %s as failed: %v", sync.ID, err)
+		}
+		return
+	}
+
+	if err := w.syncRepo.MarkRetry(ctx, sync.ID, err.Error(), time.Now().Add(backoff(sync.Attempts))); err != nil {
+		log.Printf("failed to reschedule expense sync %s: %v", sync.ID, err)
+	}
+}
+
+func (w *ExpenseSyncWorker) deliver(ctx context.Context, sync *database.ExpenseSync) error {
+	saveDestination, err := w.destinationRepo.GetByID(ctx, sync.DestinationID)
+	if err != nil {
+		return fmt.Errorf("failed to get destination: %w", err)
+	}
+
+	impl, err := w.registry.Get(saveDestination.Destination)
+	if err != nil {
+		return err
+	}
+
+	if sync.Operation == database.SyncOperation_Delete {
+		return impl.Delete(ctx, saveDestination, sync.ExpenseID)
+	}
+
+	/*
+		Inserts and updates always send the current state of the expense, so
+		retries and out of order syncs end up with the same result. If the
+		expense was deleted in the meantime it is removed instead.
+	*/
+	rows, err := w.expenseRepo.GetSheetsRowsByIDs(ctx, []uuid.UUID{sync.ExpenseID})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve expense: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return impl.Delete(ctx, saveDestination, sync.ExpenseID)
+	}
+
+	return impl.Upsert(ctx, saveDestination, &rows[0])
+}
+
+// Exponential backoff starting at baseBackoff and capped at maxBackoff
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
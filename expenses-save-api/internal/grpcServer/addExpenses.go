@@ -0,0 +1,187 @@
+package grpcserver
+
+import (
+	"context"
+	stdErrors "errors"
+	"io"
+	"log"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+)
+
+// Expenses received through AddExpenses are validated and inserted in chunks of this size, each in one transaction
+const addExpensesChunkSize = 100
+
+/*
+Client streaming version of AddExpense. Expenses are processed in chunks as
+they arrive and the reply has the result of each one, so a failing expense
+does not stop the others. Their syncs are batched by the sync worker, which
+sends each chunk to the destinations in a few requests.
+*/
+func (s *server) AddExpenses(stream grpc.ClientStreamingServer[proto.NewExpenseRequest, proto.AddExpensesReply]) error {
+	ctx := stream.Context()
+	reply := &proto.AddExpensesReply{Results: []*proto.AddExpenseResult{}}
+
+	var (
+		userID uuid.UUID
+		chunk  []*proto.NewExpenseRequest
+	)
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		userID, err = authorizedUserID(ctx, in.UserId)
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, in)
+		if len(chunk) == addExpensesChunkSize {
+			reply.Results = append(reply.Results, s.addExpensesChunk(ctx, userID, len(reply.Results), chunk)...)
+			chunk = nil
+		}
+	}
+
+	if len(chunk) > 0 {
+		reply.Results = append(reply.Results, s.addExpensesChunk(ctx, userID, len(reply.Results), chunk)...)
+	}
+
+	for _, result := range reply.Results {
+		if result.Code == int32(errors.Success) {
+			reply.SavedCount++
+		} else {
+			reply.FailedCount++
+		}
+	}
+
+	log.Printf("added expenses in bulk: %d saved, %d failed", reply.SavedCount, reply.FailedCount)
+
+	return stream.SendAndClose(reply)
+}
+
+// Returns the result of each request of the chunk, offset is the index of its first request in the stream
+func (s *server) addExpensesChunk(ctx context.Context, userID uuid.UUID, offset int, chunk []*proto.NewExpenseRequest) []*proto.AddExpenseResult {
+	results := make([]*proto.AddExpenseResult, len(chunk))
+	for i := range chunk {
+		results[i] = &proto.AddExpenseResult{Index: int32(offset + i)}
+	}
+
+	// Replays are answered before validating, the names may have changed since the original request
+	var keys []string
+	for _, in := range chunk {
+		if in.IdempotencyKey != "" {
+			keys = append(keys, in.IdempotencyKey)
+		}
+	}
+
+	existing := map[string][]uuid.UUID{}
+	if len(keys) > 0 {
+		ids, err := s.expenseService.GetIDsByIdempotencyKeys(ctx, userID, keys)
+		if err != nil {
+			log.Printf("failed to get expenses by idempotency key: %v", err)
+			for _, result := range results {
+				setResultError(result, err)
+			}
+			return results
+		}
+		existing = ids
+	}
+
+	var (
+		expenses []*database.Expense
+		pending  []*proto.AddExpenseResult
+	)
+
+	for i, in := range chunk {
+		if ids, ok := existing[in.IdempotencyKey]; ok {
+			// Same reply as when the purchase was inserted, like AddExpense
+			if in.GetExpenseInfo().GetInstallmentMonths() > 1 || len(ids) > 1 {
+				setResultInstallments(results[i], ids)
+			} else {
+				setResultSuccess(results[i], ids[0])
+			}
+			continue
+		}
+
+		expense, err := s.expenseValidatorService.GetExpenseFromRequest(ctx, userID, in)
+		if err != nil {
+			setResultError(results[i], err)
+			continue
+		}
+
+		if in.IdempotencyKey != "" {
+			expense.IdempotencyKey = &in.IdempotencyKey
+		}
+
+		// Installment purchases create many expenses, they are inserted on their own
+		if in.ExpenseInfo.InstallmentMonths > 1 {
+			ids, err := s.expenseService.InsertInstallmentExpense(ctx, expense, int(in.ExpenseInfo.InstallmentMonths))
+			if err != nil {
+				setResultError(results[i], err)
+				continue
+			}
+
+			setResultInstallments(results[i], ids)
+			continue
+		}
+
+		expenses = append(expenses, expense)
+		pending = append(pending, results[i])
+	}
+
+	if len(expenses) == 0 {
+		return results
+	}
+
+	ids, err := s.expenseService.InsertExpenses(ctx, userID, expenses)
+	if err != nil {
+		log.Printf("failed to insert expenses: %v", err)
+		for _, result := range pending {
+			setResultError(result, err)
+		}
+		return results
+	}
+
+	for i, result := range pending {
+		setResultSuccess(result, ids[i])
+	}
+
+	return results
+}
+
+func setResultSuccess(result *proto.AddExpenseResult, expenseID uuid.UUID) {
+	result.Code = int32(errors.Success)
+	result.Message = "success"
+	if expenseID != uuid.Nil {
+		result.ExpenseId = expenseID.String()
+	}
+}
+
+func setResultInstallments(result *proto.AddExpenseResult, expenseIDs []uuid.UUID) {
+	setResultSuccess(result, uuid.Nil)
+	for _, id := range expenseIDs {
+		result.InstallmentExpenseIds = append(result.InstallmentExpenseIds, id.String())
+	}
+}
+
+func setResultError(result *proto.AddExpenseResult, err error) {
+	var validationErr *errors.ValidationError
+	if stdErrors.As(err, &validationErr) {
+		result.Code = validationErr.Code
+		result.Message = validationErr.Message
+		return
+	}
+
+	result.Code = int32(errors.InternalError)
+	result.Message = err.Error()
+}
@@ -0,0 +1,341 @@
+package grpcserver
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+These RPCs expose the same operations as the HTTP ExpenseController so
+internal services do not need to go through the UI-facing API.
+*/
+
+func (s *server) ListExpenses(ctx context.Context, in *proto.ListExpensesRequest) (*proto.ListExpensesReply, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryID, err := parseOptionalUUID("categoryId", in.CategoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	subcategoryID, err := parseOptionalUUID("subcategoryId", in.SubcategoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, err := s.expenseService.GetExpenses(ctx, userID, in.StartDate, in.EndDate, categoryID, subcategoryID)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	reply := &proto.ListExpensesReply{Expenses: make([]*proto.Expense, len(expenses))}
+	for i := range expenses {
+		reply.Expenses[i] = expenseToProto(&expenses[i])
+	}
+
+	return reply, nil
+}
+
+func (s *server) GetExpense(ctx context.Context, in *proto.GetExpenseRequest) (*proto.Expense, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	expenseID, err := parseUUID("expenseId", in.ExpenseId)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.expenseService.GetExpense(ctx, userID, expenseID)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return expenseToProto(e), nil
+}
+
+func (s *server) UpdateExpense(ctx context.Context, in *proto.UpdateExpenseRequest) (*proto.Expense, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	expenseID, err := parseUUID("expenseId", in.ExpenseId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The service expects IDs that were already validated. The date, the amounts
+	// and the default payment method depend on the preferences and are checked there
+	if _, err := parseOptionalUUID("paymentMethodId", in.PaymentMethodId); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseUUID("categoryId", in.CategoryId); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseOptionalUUID("subcategoryId", in.GetSubcategoryId()); err != nil {
+		return nil, err
+	}
+
+	if _, err := parseOptionalUUID("recurrentExpenseId", in.GetRecurrentExpenseId()); err != nil {
+		return nil, err
+	}
+
+	amount, err := parseAmount("amount", in.AmountDecimal, in.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	arsAmount, err := parseAmount("arsAmount", in.ArsAmountDecimal, in.ArsAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	usdAmount, err := parseAmount("usdAmount", in.UsdAmountDecimal, in.UsdAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &expense.ExpensePayload{
+		Description:        in.Description,
+		PaymentMethodID:    in.PaymentMethodId,
+		Amount:             amount,
+		Currency:           in.Currency,
+		ArsAmount:          arsAmount,
+		UsdAmount:          usdAmount,
+		CategoryID:         in.CategoryId,
+		SubcategoryID:      in.SubcategoryId,
+		RecurrentExpenseID: in.RecurrentExpenseId,
+		Date:               in.Date,
+		Timezone:           in.Timezone,
+	}
+
+	e, err := s.expenseService.UpdateExpense(ctx, userID, expenseID, payload)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return expenseToProto(e), nil
+}
+
+func (s *server) DeleteExpense(ctx context.Context, in *proto.DeleteExpenseRequest) (*proto.DeleteExpenseReply, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	expenseID, err := parseUUID("expenseId", in.ExpenseId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.expenseService.DeleteExpense(ctx, userID, expenseID); err != nil {
+		return nil, serviceError(err)
+	}
+
+	return &proto.DeleteExpenseReply{}, nil
+}
+
+func (s *server) GetInsertInformation(ctx context.Context, in *proto.GetInsertInformationRequest) (*proto.InsertInformation, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.expenseService.GetExpenseInsertInformation(ctx, userID, in.WithRecurrent)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	reply := &proto.InsertInformation{
+		Categories:        make([]*proto.Category, len(info.Categories)),
+		Subcategories:     make([]*proto.Subcategory, len(info.Subcategories)),
+		PaymentMethods:    make([]*proto.PaymentMethod, len(info.PaymentMethods)),
+		RecurrentExpenses: make([]*proto.RecurrentExpense, len(info.RecurrentExpenses)),
+		Currencies:        make([]*proto.Currency, len(info.Currencies)),
+		UsdArsFx:          info.UsdArsFx,
+		UsdArsFxStale:     info.UsdArsFxStale,
+	}
+
+	for i, c := range info.Categories {
+		reply.Categories[i] = &proto.Category{Id: c.Id.String(), Name: c.Name}
+	}
+
+	for i, sc := range info.Subcategories {
+		reply.Subcategories[i] = &proto.Subcategory{Id: sc.Id.String(), CategoryId: sc.CategoryID.String(), Name: sc.Name}
+	}
+
+	for i, pm := range info.PaymentMethods {
+		reply.PaymentMethods[i] = &proto.PaymentMethod{Id: pm.Id.String(), Name: pm.Name, RateType: (*string)(pm.RateType)}
+	}
+
+	for i := range info.RecurrentExpenses {
+		reply.RecurrentExpenses[i] = recurrentExpenseToProto(&info.RecurrentExpenses[i], info.Timezone)
+	}
+
+	for i, c := range info.Currencies {
+		reply.Currencies[i] = &proto.Currency{Code: c.Code, Name: c.Name}
+	}
+
+	return reply, nil
+}
+
+// Maps the errors returned by ExpenseService into gRPC status errors
+func serviceError(err error) error {
+	log.Printf("expense service error: %v", err)
+
+	if stdErrors.Is(err, pgx.ErrNoRows) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	return errors.StatusFromError(err)
+}
+
+func parseUUID(field string, value string) (uuid.UUID, error) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, errors.StatusFromError(&errors.ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid %s", field),
+			Code:    int32(errors.InvalidPayload),
+		})
+	}
+
+	return id, nil
+}
+
+// The decimal string is used when it is set, otherwise the double
+func parseAmount(field string, decimal string, value float64) (money.Money, error) {
+	amount, err := money.FromDecimalOrFloat(decimal, value)
+	if err != nil {
+		return money.Money{}, errors.StatusFromError(&errors.ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid %s: %v", field, err),
+			Code:    int32(errors.InvalidPayload),
+		})
+	}
+
+	return amount, nil
+}
+
+func parseOptionalUUID(field string, value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	id, err := parseUUID(field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func optionalUUIDString(id *uuid.UUID) *string {
+	if id == nil {
+		return nil
+	}
+
+	str := id.String()
+	return &str
+}
+
+func expenseToProto(e *database.Expense) *proto.Expense {
+	date := dates.In(e.Date, e.Timezone)
+
+	return &proto.Expense{
+		Id:                    e.ID.String(),
+		UserId:                e.UserID.String(),
+		Description:           e.Description,
+		PaymentMethodId:       e.PaymentMethodID.String(),
+		ArsAmount:             e.ARSAmount.Float64(),
+		UsdAmount:             e.USDAmount.Float64(),
+		CategoryId:            e.CategoryID.String(),
+		SubcategoryId:         optionalUUIDString(e.SubcategoryID),
+		RecurrentExpenseId:    optionalUUIDString(e.RecurrentExpenseID),
+		InstallmentsExpenseId: optionalUUIDString(e.InstallementsExpenseID),
+		Date:                  date.Format(dates.DateLayout),
+		Timezone:              e.Timezone,
+		Timestamp:             date.Format(time.RFC3339),
+		Currency:              e.Currency,
+		OriginalAmount:        e.OriginalAmount.Float64(),
+		UsdArsRate:            e.UsdArsRate,
+		CurrencyUsdRate:       e.CurrencyUsdRate,
+		RateType:              (*string)(e.RateType),
+		RateProvider:          e.RateProvider,
+		RateFetchedAt:         optionalTimestamp(e.RateFetchedAt),
+		ArsAmountDecimal:      e.ARSAmount.String(),
+		UsdAmountDecimal:      e.USDAmount.String(),
+		OriginalAmountDecimal: e.OriginalAmount.String(),
+	}
+}
+
+func optionalTimestamp(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+
+	str := t.Format(time.RFC3339)
+	return &str
+}
+
+func optionalFloat(amount *money.Money) *float64 {
+	if amount == nil {
+		return nil
+	}
+
+	f := amount.Float64()
+	return &f
+}
+
+func optionalDecimal(amount *money.Money) *string {
+	if amount == nil {
+		return nil
+	}
+
+	str := amount.String()
+	return &str
+}
+
+// The days of the recurrent expense are formatted in timezone, the one of the user
+func recurrentExpenseToProto(r *database.RecurrentExpense, timezone string) *proto.RecurrentExpense {
+	var endDate *string
+	if r.EndDate != nil {
+		str := dates.In(*r.EndDate, timezone).Format(dates.DateLayout)
+		endDate = &str
+	}
+
+	return &proto.RecurrentExpense{
+		Id:               r.ID.String(),
+		Description:      r.Description,
+		PaymentMethodId:  r.PaymentMethodID.String(),
+		ArsAmount:        optionalFloat(r.ARSAmount),
+		UsdAmount:        optionalFloat(r.USDAmount),
+		CategoryId:       r.CategoryID.String(),
+		SubcategoryId:    optionalUUIDString(r.SubcategoryID),
+		StartDate:        dates.In(r.StartDate, timezone).Format(dates.DateLayout),
+		EndDate:          endDate,
+		ArsAmountDecimal: optionalDecimal(r.ARSAmount),
+		UsdAmountDecimal: optionalDecimal(r.USDAmount),
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"log"
 	"net"
 
-	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/preference"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
-	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
 	"google.golang.org/grpc"
 )
@@ -17,9 +18,23 @@ type GrpcServer struct {
 	server     *server
 }
 
-func NewGrpcServer(sheetsService *sheets.SheetsService, dbService *database.DatabaseService, expenseValidatorService *validator.ExpenseValidatorService) *GrpcServer {
-	grpcServer := grpc.NewServer()
-	server := &server{sheetsService: sheetsService, dbService: dbService, expenseValidatorService: expenseValidatorService}
+func NewGrpcServer(
+	expenseValidatorService *validator.ExpenseValidatorService,
+	expenseService *expense.ExpenseService,
+	preferenceService *preference.PreferenceService,
+	userRepo *repository.UserRepository,
+) *GrpcServer {
+	if len(*env.GRPC_CLIENT_SECRET) == 0 {
+		log.Fatalf("environment variable GRPC_CLIENT_SECRET not found")
+	}
+
+	auth := newAuthenticator(userRepo, *env.GRPC_CLIENT_SECRET)
+	grpcServer := grpc.NewServer(interceptorOptions(auth)...)
+	server := &server{
+		expenseValidatorService: expenseValidatorService,
+		expenseService:          expenseService,
+		preferenceService:       preferenceService,
+	}
 	proto.RegisterExpensesServer(grpcServer, server)
 	return &GrpcServer{grpcServer: grpcServer, server: server}
 }
@@ -0,0 +1,289 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Same header the HTTP API receives from auth-service
+	userIDMetadataKey       = "internal-user-id"
+	clientSecretMetadataKey = "client-secret"
+	requestIDMetadataKey    = "x-request-id"
+
+	// Applied to calls without a deadline, and as an upper bound for the ones that have it
+	unaryTimeout  = 30 * time.Second
+	streamTimeout = 5 * time.Minute
+
+	verifiedUserTTL = 5 * time.Minute
+)
+
+type contextKey int
+
+const (
+	userIDContextKey contextKey = iota
+	requestIDContextKey
+)
+
+func userIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(uuid.UUID)
+	return userID, ok
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+/*
+Interceptors run in this order: request ID, logging, panic recovery,
+deadline and authentication. Recovery runs inside logging so recovered
+panics are logged as Internal errors with their request ID.
+*/
+func interceptorOptions(auth *authenticator) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			requestIDUnaryInterceptor,
+			loggingUnaryInterceptor,
+			recoveryUnaryInterceptor,
+			deadlineUnaryInterceptor,
+			auth.unaryInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			requestIDStreamInterceptor,
+			loggingStreamInterceptor,
+			recoveryStreamInterceptor,
+			deadlineStreamInterceptor,
+			auth.streamInterceptor,
+		),
+	}
+}
+
+func requestIDUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withRequestID(ctx), req)
+}
+
+func requestIDStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: withRequestID(ss.Context())})
+}
+
+func loggingUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+func loggingStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	logCall(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+func recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverPanic(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverPanic(ss.Context(), info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+func deadlineUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, cancel := withTimeout(ctx, unaryTimeout)
+	defer cancel()
+	return handler(ctx, req)
+}
+
+func deadlineStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := withTimeout(ss.Context(), streamTimeout)
+	defer cancel()
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+}
+
+/*
+Callers have to send the shared client secret and the ID of the user they
+act on behalf of. The user has to exist in the users table.
+*/
+type authenticator struct {
+	userRepo     *repository.UserRepository
+	clientSecret string
+
+	// Users that were found in the database recently, to avoid a query per call
+	verifiedUsers      map[uuid.UUID]time.Time
+	verifiedUsersMutex sync.Mutex
+}
+
+func newAuthenticator(userRepo *repository.UserRepository, clientSecret string) *authenticator {
+	return &authenticator{
+		userRepo:      userRepo,
+		clientSecret:  clientSecret,
+		verifiedUsers: make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *authenticator) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+}
+
+// Uses the request ID sent by the client or generates a new one, and sends it back in the response headers
+func withRequestID(ctx context.Context) context.Context {
+	requestID := firstMetadataValue(ctx, requestIDMetadataKey)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, requestID))
+
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+	secret := firstMetadataValue(ctx, clientSecretMetadataKey)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(a.clientSecret)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid client secret")
+	}
+
+	userIDStr := firstMetadataValue(ctx, userIDMetadataKey)
+	if userIDStr == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "%s metadata is required", userIDMetadataKey)
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid user ID format")
+	}
+
+	if err := a.verifyUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, userIDContextKey, userID), nil
+}
+
+func (a *authenticator) verifyUser(ctx context.Context, userID uuid.UUID) error {
+	a.verifiedUsersMutex.Lock()
+	verifiedAt, ok := a.verifiedUsers[userID]
+	a.verifiedUsersMutex.Unlock()
+
+	if ok && time.Since(verifiedAt) < verifiedUserTTL {
+		return nil
+	}
+
+	exists, err := a.userRepo.Exists(ctx, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to verify user: %v", err)
+	}
+
+	if !exists {
+		return status.Error(codes.PermissionDenied, "user does not exist")
+	}
+
+	a.verifiedUsersMutex.Lock()
+	a.verifiedUsers[userID] = time.Now()
+	a.verifiedUsersMutex.Unlock()
+
+	return nil
+}
+
+// Applies the timeout unless the client already set a shorter deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+func recoverPanic(ctx context.Context, method string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	slog.ErrorContext(ctx, "panic in gRPC handler",
+		"requestId", requestIDFromContext(ctx),
+		"method", method,
+		"panic", r,
+		"stack", string(debug.Stack()),
+	)
+
+	*err = status.Error(codes.Internal, "internal error")
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	attrs := []any{
+		"requestId", requestIDFromContext(ctx),
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+	}
+
+	if userID := firstMetadataValue(ctx, userIDMetadataKey); userID != "" {
+		attrs = append(attrs, "userId", userID)
+	}
+
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+
+	if code == codes.Internal || code == codes.Unknown {
+		slog.ErrorContext(ctx, "gRPC call", attrs...)
+		return
+	}
+
+	slog.InfoContext(ctx, "gRPC call", attrs...)
+}
+
+func firstMetadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// Replaces the context of a stream so handlers see the values set by the interceptors
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
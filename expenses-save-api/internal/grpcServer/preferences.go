@@ -0,0 +1,58 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/preference"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
+)
+
+func (s *server) GetPreferences(ctx context.Context, in *proto.GetPreferencesRequest) (*proto.Preferences, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.preferenceService.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return preferencesToProto(p), nil
+}
+
+func (s *server) UpdatePreferences(ctx context.Context, in *proto.UpdatePreferencesRequest) (*proto.Preferences, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultPaymentMethodID, err := parseOptionalUUID("defaultPaymentMethodId", in.DefaultPaymentMethodId)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.preferenceService.UpdatePreferences(ctx, userID, &preference.PreferencePayload{
+		Timezone:               in.Timezone,
+		DefaultCurrency:        in.DefaultCurrency,
+		DefaultPaymentMethodID: defaultPaymentMethodID,
+		DateFormat:             database.DateFormat(in.DateFormat),
+		RateType:               database.RateType(in.RateType),
+	})
+	if err != nil {
+		return nil, serviceError(err)
+	}
+
+	return preferencesToProto(p), nil
+}
+
+func preferencesToProto(p *database.UserPreference) *proto.Preferences {
+	return &proto.Preferences{
+		Timezone:               p.Timezone,
+		DefaultCurrency:        p.DefaultCurrency,
+		DefaultPaymentMethodId: optionalUUIDString(p.DefaultPaymentMethodID),
+		DateFormat:             string(p.DateFormat),
+		RateType:               string(p.RateType),
+	}
+}
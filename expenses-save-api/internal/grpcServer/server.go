@@ -2,120 +2,120 @@ package grpcserver
 
 import (
 	"context"
-	stdErrors "errors"
 	"log"
-	"time"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/preference"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
-	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type server struct {
 	proto.UnimplementedExpensesServer
-	sheetsService           *sheets.SheetsService
-	dbService               *database.DatabaseService
 	expenseValidatorService *validator.ExpenseValidatorService
+	expenseService          *expense.ExpenseService
+	preferenceService       *preference.PreferenceService
 }
 
-func (s *server) AddExpense(_ context.Context, in *proto.NewExpenseRequest) (*proto.ExpenseReply, error) {
-	log.Printf("received: %v", in.String())
-
-	userID, err := uuid.Parse(in.UserId)
+func (s *server) AddExpense(ctx context.Context, in *proto.NewExpenseRequest) (*proto.ExpenseReply, error) {
+	userID, err := authorizedUserID(ctx, in.UserId)
 	if err != nil {
-		log.Printf("invalid user ID: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InvalidPayload), Message: "invalid user ID"}, nil
+		return nil, err
 	}
 
-	// Do db stuff
+	// Replays are answered before validating, the names may have changed since the original request
+	if in.IdempotencyKey != "" {
+		existingIDs, err := s.expenseService.GetExpenseIDsByIdempotencyKey(ctx, userID, in.IdempotencyKey)
+		if err != nil {
+			log.Printf("failed to get expense by idempotency key: %v", err)
+			return errorReply(err)
+		}
+		if existingIDs != nil {
+			log.Printf("expense with idempotency key %s already exists", in.IdempotencyKey)
+			return successReply(existingIDs), nil
+		}
+	}
 
-	expense, err := s.expenseValidatorService.GetExpenseFromRequest(userID, in)
+	expense, err := s.expenseValidatorService.GetExpenseFromRequest(ctx, userID, in)
 
 	if err != nil {
 		log.Printf("failed to get expense from request: %v", err)
-		var validationErr *errors.ValidationError
-
-		if stdErrors.As(err, &validationErr) {
-			return &proto.ExpenseReply{Code: validationErr.Code, Message: validationErr.Error()}, nil
-		}
-
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
+		return errorReply(err)
 	}
 
-	expenseID, err := s.dbService.InsertExpense(expense)
+	if in.IdempotencyKey != "" {
+		expense.IdempotencyKey = &in.IdempotencyKey
+	}
 
+	// Destinations are synced in the background by the expense sync worker
+	expenseIDs, err := s.insertExpense(ctx, expense, int(in.ExpenseInfo.InstallmentMonths))
 	if err != nil {
 		log.Printf("failed to insert expense: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
+		return errorReply(err)
 	}
 
-	// Do old stuff. TODO: Refactor it
-	saveDestinationRows, err := s.dbService.GetDestinationsByUserId(userID)
-	if err != nil {
-		log.Printf("failed to get user expenses: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
-	}
+	return successReply(expenseIDs), nil
+}
 
-	/*
-		This sucks. Refactor it please
-
-		Right now I am only supporting a single destination place. Ideally this
-		should support multiple destinations
-	*/
-	var sheetsInfo *database.GoogleSheetsInfo
-	for _, saveDestination := range saveDestinationRows {
-		if saveDestination.Destination == database.DestinationType_GoogleSheets {
-			sheetsInfo, err = saveDestination.GetGoogleSheetsInfo()
-			if err != nil {
-				log.Printf("failed to get Google Sheets info: %v", err)
-				continue
-			}
-			if sheetsInfo != nil {
-				break
-			}
-		}
+// Purchases with more than one installment are split through the same logic as the HTTP API
+func (s *server) insertExpense(ctx context.Context, expense *database.Expense, installmentMonths int) ([]uuid.UUID, error) {
+	if installmentMonths > 1 {
+		return s.expenseService.InsertInstallmentExpense(ctx, expense, installmentMonths)
 	}
 
-	if sheetsInfo == nil {
-		log.Printf("no Google Sheets configuration found for user %s", userID)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: "no Google Sheets configuration found"}, nil
+	inserted, err := s.expenseService.InsertExpense(ctx, expense)
+	if err != nil {
+		return nil, err
 	}
 
-	rowExpense, err := s.dbService.RetrieveExpenseForSheets(expenseID)
+	return []uuid.UUID{inserted.ID}, nil
+}
 
-	if err != nil {
-		log.Printf("failed to retrieve expense for sheets: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
+func successReply(expenseIDs []uuid.UUID) *proto.ExpenseReply {
+	ids := make([]string, 0, len(expenseIDs))
+	for _, id := range expenseIDs {
+		ids = append(ids, id.String())
 	}
 
-	// Load Buenos Aires timezone for formatting
-	buenosAiresLoc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
-	if err != nil {
-		log.Printf("failed to load Buenos Aires timezone: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
+	return &proto.ExpenseReply{Code: int32(errors.Success), Message: "success", ExpenseIds: ids}
+}
+
+/*
+Failures are returned as gRPC status errors. Old clients that expect the
+error inside ExpenseReply can still get it by enabling GRPC_LEGACY_REPLY.
+*/
+func errorReply(err error) (*proto.ExpenseReply, error) {
+	if *env.GRPC_LEGACY_REPLY {
+		return errors.ReplyFromError(err), nil
 	}
 
-	row := []interface{}{
-		rowExpense.ID,
-		rowExpense.Date.In(buenosAiresLoc).Format("2006-01-02"),
-		rowExpense.Description,
-		rowExpense.PaymentMethodName,
-		rowExpense.ARSAmount,
-		rowExpense.USDAmount,
-		rowExpense.CategoryName,
-		rowExpense.SubcategoryName,
-		rowExpense.CreatedDate,
+	return nil, errors.StatusFromError(err)
+}
+
+/*
+Returns the user authenticated by the interceptors. The user ID in the
+request is optional, but if it is sent it has to be the same user.
+*/
+func authorizedUserID(ctx context.Context, requestUserID string) (uuid.UUID, error) {
+	userID, ok := userIDFromContext(ctx)
+	if !ok {
+		return uuid.Nil, status.Error(codes.Unauthenticated, "user is not authenticated")
 	}
 
-	err = s.sheetsService.AppendRow(sheetsInfo.SheetID, sheetsInfo.SheetName, &row)
+	if requestUserID == "" {
+		return userID, nil
+	}
 
-	if err != nil {
-		log.Printf("failed to append row: %v", err)
-		return &proto.ExpenseReply{Code: int32(errors.InternalError), Message: err.Error()}, nil
+	parsed, err := uuid.Parse(requestUserID)
+	if err != nil || parsed != userID {
+		return uuid.Nil, status.Error(codes.PermissionDenied, "userId does not match the authenticated user")
 	}
 
-	return &proto.ExpenseReply{Code: int32(errors.Success), Message: "success"}, nil
+	return userID, nil
 }
@@ -0,0 +1,88 @@
+package alias
+
+import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type AliasController struct {
+	aliasService *AliasService
+}
+
+func NewAliasController(aliasService *AliasService) *AliasController {
+	return &AliasController{
+		aliasService: aliasService,
+	}
+}
+
+func (c *AliasController) GetAliases(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	aliases, err := c.aliasService.GetAliases(ctx.Context(), userID)
+	if err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(aliases)
+}
+
+func (c *AliasController) AddAlias(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	var payload AliasPayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	a, err := c.aliasService.Insert(ctx.Context(), userID, &payload)
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Info("Added alias")
+
+	return ctx.Status(fiber.StatusCreated).JSON(a)
+}
+
+func (c *AliasController) DeleteAlias(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	id, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid alias ID"})
+	}
+
+	if err := c.aliasService.Delete(ctx.Context(), id, userID); err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Info("Deleted alias")
+
+	return ctx.Status(fiber.StatusNoContent).Send(nil)
+}
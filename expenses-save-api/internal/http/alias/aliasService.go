@@ -0,0 +1,88 @@
+package alias
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type AliasService struct {
+	nameAliasRepo *repository.NameAliasRepository
+}
+
+type AliasPayload struct {
+	Kind     database.AliasKind `json:"kind"`
+	Alias    string             `json:"alias"`
+	TargetID uuid.UUID          `json:"targetId"`
+}
+
+func NewAliasService(nameAliasRepo *repository.NameAliasRepository) *AliasService {
+	return &AliasService{
+		nameAliasRepo: nameAliasRepo,
+	}
+}
+
+func (s *AliasService) GetAliases(ctx context.Context, userID uuid.UUID) ([]database.NameAlias, error) {
+	return s.nameAliasRepo.GetByUserID(ctx, userID)
+}
+
+func (s *AliasService) Insert(ctx context.Context, userID uuid.UUID, payload *AliasPayload) (*database.NameAlias, error) {
+	aliasName := strings.TrimSpace(payload.Alias)
+	if aliasName == "" {
+		return nil, &errors.ValidationError{Field: "alias", Message: "alias is required", Code: int32(errors.InvalidPayload)}
+	}
+
+	switch payload.Kind {
+	case database.AliasKind_Category, database.AliasKind_Subcategory, database.AliasKind_PaymentMethod:
+	default:
+		return nil, &errors.ValidationError{
+			Field:   "kind",
+			Message: fmt.Sprintf("kind has to be category, subcategory or payment_method, found %s", payload.Kind),
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	exists, err := s.nameAliasRepo.TargetExists(ctx, userID, payload.Kind, payload.TargetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check alias target: %w", err)
+	}
+	if !exists {
+		return nil, &errors.ValidationError{
+			Field:   "targetId",
+			Message: fmt.Sprintf("%s not found for user", payload.Kind),
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	a, err := s.nameAliasRepo.Insert(ctx, userID, payload.Kind, aliasName, payload.TargetID)
+	if err != nil {
+		if repository.IsDuplicatedAlias(err) {
+			return nil, &errors.ValidationError{
+				Field:   "alias",
+				Message: fmt.Sprintf("alias '%s' already exists", aliasName),
+				Code:    int32(errors.InvalidPayload),
+			}
+		}
+		return nil, fmt.Errorf("failed to insert alias: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *AliasService) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	if err := s.nameAliasRepo.Delete(ctx, id, userID); err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("alias not found: %w", err)
+		}
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+
+	return nil
+}
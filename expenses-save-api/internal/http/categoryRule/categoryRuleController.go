@@ -0,0 +1,141 @@
+package categoryrule
+
+import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type CategoryRuleController struct {
+	categoryRuleService *CategoryRuleService
+}
+
+func NewCategoryRuleController(categoryRuleService *CategoryRuleService) *CategoryRuleController {
+	return &CategoryRuleController{
+		categoryRuleService: categoryRuleService,
+	}
+}
+
+func (c *CategoryRuleController) GetRules(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	rules, err := c.categoryRuleService.GetRules(ctx.Context(), userID)
+	if err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(rules)
+}
+
+func (c *CategoryRuleController) AddRule(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	var payload CategoryRulePayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	rule, err := c.categoryRuleService.Insert(ctx.Context(), userID, &payload)
+	if err != nil {
+		return errorResponse(ctx, err)
+	}
+
+	log.Info("Added category rule")
+
+	return ctx.Status(fiber.StatusCreated).JSON(rule)
+}
+
+func (c *CategoryRuleController) UpdateRule(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	id, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid category rule ID"})
+	}
+
+	var payload CategoryRulePayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	rule, err := c.categoryRuleService.Update(ctx.Context(), id, userID, &payload)
+	if err != nil {
+		return errorResponse(ctx, err)
+	}
+
+	log.Info("Updated category rule")
+
+	return ctx.Status(fiber.StatusOK).JSON(rule)
+}
+
+func (c *CategoryRuleController) DeleteRule(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	id, err := uuid.Parse(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid category rule ID"})
+	}
+
+	if err := c.categoryRuleService.Delete(ctx.Context(), id, userID); err != nil {
+		return errorResponse(ctx, err)
+	}
+
+	log.Info("Deleted category rule")
+
+	return ctx.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// Runs the rule in the body against the expenses between startDate and endDate (optional)
+func (c *CategoryRuleController) TestRule(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	var payload CategoryRulePayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	result, err := c.categoryRuleService.Test(ctx.Context(), userID, &payload, ctx.Query("startDate"), ctx.Query("endDate"))
+	if err != nil {
+		return errorResponse(ctx, err)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+func errorResponse(ctx fiber.Ctx, err error) error {
+	var validationErr *errors.ValidationError
+	if stdErrors.As(err, &validationErr) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+	}
+
+	if stdErrors.Is(err, pgx.ErrNoRows) {
+		return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Error(err)
+	return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
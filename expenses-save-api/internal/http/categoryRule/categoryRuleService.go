@@ -0,0 +1,284 @@
+package categoryrule
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"time"
+
+	categorymatcher "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/categoryMatcher"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type CategoryRuleService struct {
+	categoryRuleRepo   *repository.CategoryRuleRepository
+	expenseRepo        *repository.ExpenseRepository
+	userPreferenceRepo *repository.UserPreferenceRepository
+}
+
+type CategoryRulePayload struct {
+	Name                  string                 `json:"name"`
+	Priority              int                    `json:"priority"`
+	Field                 database.RuleField     `json:"field"`
+	MatchType             database.RuleMatchType `json:"matchType"`
+	Pattern               string                 `json:"pattern"`
+	MinAmount             *money.Money           `json:"minAmount"`
+	MaxAmount             *money.Money           `json:"maxAmount"`
+	PaymentMethodID       *uuid.UUID             `json:"paymentMethodId"`
+	CategoryID            uuid.UUID              `json:"categoryId"`
+	SubcategoryID         *uuid.UUID             `json:"subcategoryId"`
+	NormalizedDescription *string                `json:"normalizedDescription"`
+	Enabled               *bool                  `json:"enabled"` // defaults to true
+}
+
+type RuleTestMatch struct {
+	Expense               database.Expense `json:"expense"`
+	CategoryID            uuid.UUID        `json:"categoryId"`
+	SubcategoryID         *uuid.UUID       `json:"subcategoryId"`
+	NormalizedDescription string           `json:"normalizedDescription"`
+	Changed               bool             `json:"changed"`
+}
+
+// Result of running a rule against the expenses the user already has
+type RuleTestResult struct {
+	Evaluated int             `json:"evaluated"`
+	Matched   int             `json:"matched"`
+	Changed   int             `json:"changed"`
+	Matches   []RuleTestMatch `json:"matches"`
+}
+
+func NewCategoryRuleService(categoryRuleRepo *repository.CategoryRuleRepository, expenseRepo *repository.ExpenseRepository, userPreferenceRepo *repository.UserPreferenceRepository) *CategoryRuleService {
+	return &CategoryRuleService{
+		categoryRuleRepo:   categoryRuleRepo,
+		expenseRepo:        expenseRepo,
+		userPreferenceRepo: userPreferenceRepo,
+	}
+}
+
+func (s *CategoryRuleService) GetRules(ctx context.Context, userID uuid.UUID) ([]database.CategoryRule, error) {
+	return s.categoryRuleRepo.GetByUserID(ctx, userID)
+}
+
+func (s *CategoryRuleService) Insert(ctx context.Context, userID uuid.UUID, payload *CategoryRulePayload) (*database.CategoryRule, error) {
+	rule, err := s.ruleFromPayload(ctx, userID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := s.categoryRuleRepo.Insert(ctx, rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert category rule: %w", err)
+	}
+
+	return inserted, nil
+}
+
+func (s *CategoryRuleService) Update(ctx context.Context, id uuid.UUID, userID uuid.UUID, payload *CategoryRulePayload) (*database.CategoryRule, error) {
+	rule, err := s.ruleFromPayload(ctx, userID, payload)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+
+	updated, err := s.categoryRuleRepo.Update(ctx, rule)
+	if err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("category rule not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to update category rule: %w", err)
+	}
+
+	return updated, nil
+}
+
+func (s *CategoryRuleService) Delete(ctx context.Context, id uuid.UUID, userID uuid.UUID) error {
+	if err := s.categoryRuleRepo.Delete(ctx, id, userID); err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("category rule not found: %w", err)
+		}
+		return fmt.Errorf("failed to delete category rule: %w", err)
+	}
+
+	return nil
+}
+
+/*
+Runs a rule, which does not need to be saved, against the expenses of the
+user between the dates (both optional) and returns the ones it would match.
+Dates are YYYY-MM-DD or the date format of the user and are compared with the
+day of each expense in its timezone. Nothing is modified.
+*/
+func (s *CategoryRuleService) Test(ctx context.Context, userID uuid.UUID, payload *CategoryRulePayload, startDateStr string, endDateStr string) (*RuleTestResult, error) {
+	rule, err := s.ruleFromPayload(ctx, userID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	startDate, err := parseTestDate("startDate", startDateStr, preference)
+	if err != nil {
+		return nil, err
+	}
+
+	endDate, err := parseTestDate("endDate", endDateStr, preference)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := categorymatcher.Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, err := s.expenseRepo.GetByUserIDAndDateRange(ctx, userID, startDate, endDate, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expenses: %w", err)
+	}
+
+	result := &RuleTestResult{Evaluated: len(expenses), Matches: []RuleTestMatch{}}
+
+	for _, expense := range expenses {
+		if !compiled.Matches(categorymatcher.InputFromExpense(&expense, "")) {
+			continue
+		}
+
+		updated := expense
+		compiled.Apply(&updated)
+
+		changed := updated.CategoryID != expense.CategoryID ||
+			!sameUUID(updated.SubcategoryID, expense.SubcategoryID) ||
+			updated.Description != expense.Description
+
+		result.Matched++
+		if changed {
+			result.Changed++
+		}
+
+		result.Matches = append(result.Matches, RuleTestMatch{
+			Expense:               expense,
+			CategoryID:            updated.CategoryID,
+			SubcategoryID:         updated.SubcategoryID,
+			NormalizedDescription: updated.Description,
+			Changed:               changed,
+		})
+	}
+
+	return result, nil
+}
+
+func (s *CategoryRuleService) ruleFromPayload(ctx context.Context, userID uuid.UUID, payload *CategoryRulePayload) (*database.CategoryRule, error) {
+	rule := &database.CategoryRule{
+		UserID:                userID,
+		Name:                  payload.Name,
+		Priority:              payload.Priority,
+		Field:                 payload.Field,
+		MatchType:             payload.MatchType,
+		Pattern:               payload.Pattern,
+		MinAmount:             payload.MinAmount,
+		MaxAmount:             payload.MaxAmount,
+		PaymentMethodID:       payload.PaymentMethodID,
+		CategoryID:            payload.CategoryID,
+		SubcategoryID:         payload.SubcategoryID,
+		NormalizedDescription: payload.NormalizedDescription,
+		Enabled:               payload.Enabled == nil || *payload.Enabled,
+	}
+
+	if rule.Field == "" {
+		rule.Field = database.RuleField_Description
+	}
+
+	if rule.MatchType == "" {
+		rule.MatchType = database.RuleMatchType_Substring
+	}
+
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+
+	categoryOk, subcategoryOk, paymentMethodOk, err := s.categoryRuleRepo.CheckReferences(ctx, userID, rule.CategoryID, rule.SubcategoryID, rule.PaymentMethodID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check category rule references: %w", err)
+	}
+
+	if !categoryOk {
+		return nil, &errors.ValidationError{Field: "categoryId", Message: "category not found for user", Code: int32(errors.InvalidCategory)}
+	}
+
+	if !subcategoryOk {
+		return nil, &errors.ValidationError{Field: "subcategoryId", Message: "subcategory not found in category", Code: int32(errors.InvalidSubcategory)}
+	}
+
+	if !paymentMethodOk {
+		return nil, &errors.ValidationError{Field: "paymentMethodId", Message: "payment method not found for user", Code: int32(errors.InvalidPaymentMethod)}
+	}
+
+	return rule, nil
+}
+
+func validateRule(rule *database.CategoryRule) error {
+	if rule.Name == "" {
+		return &errors.ValidationError{Field: "name", Message: "name is required", Code: int32(errors.InvalidPayload)}
+	}
+
+	if rule.Pattern == "" {
+		return &errors.ValidationError{Field: "pattern", Message: "pattern is required", Code: int32(errors.InvalidPayload)}
+	}
+
+	if rule.Field != database.RuleField_Description && rule.Field != database.RuleField_Vendor {
+		return &errors.ValidationError{Field: "field", Message: fmt.Sprintf("field has to be description or vendor, found %s", rule.Field), Code: int32(errors.InvalidPayload)}
+	}
+
+	if rule.MatchType != database.RuleMatchType_Substring && rule.MatchType != database.RuleMatchType_Regex {
+		return &errors.ValidationError{Field: "matchType", Message: fmt.Sprintf("matchType has to be substring or regex, found %s", rule.MatchType), Code: int32(errors.InvalidPayload)}
+	}
+
+	if _, err := categorymatcher.Compile(rule); err != nil {
+		return &errors.ValidationError{Field: "pattern", Message: err.Error(), Code: int32(errors.InvalidPayload)}
+	}
+
+	if rule.MinAmount != nil && rule.MaxAmount != nil && rule.MinAmount.Cmp(*rule.MaxAmount) > 0 {
+		return &errors.ValidationError{Field: "minAmount", Message: "minAmount cannot be greater than maxAmount", Code: int32(errors.InvalidPayload)}
+	}
+
+	if rule.CategoryID == uuid.Nil {
+		return &errors.ValidationError{Field: "categoryId", Message: "categoryId is required", Code: int32(errors.InvalidPayload)}
+	}
+
+	return nil
+}
+
+// Empty dates are nil
+func parseTestDate(field string, value string, preference *database.UserPreference) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	date, err := dates.ParseDate(value, preference.DateLayout(), time.UTC)
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid %s format, expected YYYY-MM-DD or %s", field, preference.DateFormat),
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	return &date, nil
+}
+
+func sameUUID(a *uuid.UUID, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
@@ -1,22 +1,35 @@
 package expense
 
 import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/revaluation"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/log"
 	"github.com/google/uuid"
 )
 
 type ExpenseController struct {
-	expenseService *ExpenseService
+	expenseService     *ExpenseService
+	revaluationService *revaluation.RevaluationService
 }
 
-func NewExpenseController(expenseService *ExpenseService) *ExpenseController {
+func NewExpenseController(expenseService *ExpenseService, revaluationService *revaluation.RevaluationService) *ExpenseController {
 	return &ExpenseController{
-		expenseService: expenseService,
+		expenseService:     expenseService,
+		revaluationService: revaluationService,
 	}
 }
 
+type RevaluationPayload struct {
+	StartDate   string `json:"startDate"`
+	EndDate     string `json:"endDate"`
+	OnlyInvalid bool   `json:"onlyInvalid"`
+	DryRun      bool   `json:"dryRun"`
+}
+
 func (c *ExpenseController) GetInsertInformation(ctx fiber.Ctx) error {
 	userID, err := middleware.GetUserIDFromContext(ctx)
 	if err != nil {
@@ -46,13 +59,19 @@ func (c *ExpenseController) AddExpense(ctx fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
-	if payload.ArsAmount == 0 || payload.UsdAmount == 0 {
-		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ARS and USD have to be greater than 0"})
+	// Optional. Retrying a request with the same key returns the original result
+	var idempotencyKey *string
+	if key := ctx.Get("Idempotency-Key"); key != "" {
+		idempotencyKey = &key
 	}
 
 	if payload.InstallmentMonths > 0 {
-		expenseIDs, err := c.expenseService.AddInstallmentExpense(ctx.Context(), userID, &payload)
+		expenseIDs, err := c.expenseService.AddInstallmentExpense(ctx.Context(), userID, &payload, idempotencyKey)
 		if err != nil {
+			var validationErr *errors.ValidationError
+			if stdErrors.As(err, &validationErr) {
+				return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+			}
 			log.Error(err)
 			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 		}
@@ -65,8 +84,12 @@ func (c *ExpenseController) AddExpense(ctx fiber.Ctx) error {
 		})
 	}
 
-	response, err := c.expenseService.AddExpense(ctx.Context(), userID, &payload)
+	response, err := c.expenseService.AddExpense(ctx.Context(), userID, &payload, idempotencyKey)
 	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
 		log.Error(err)
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -107,8 +130,12 @@ func (c *ExpenseController) GetExpenses(ctx fiber.Ctx) error {
 
 	expenses, err := c.expenseService.GetExpenses(ctx.Context(), userID, startDate, endDate, categoryID, subcategoryID)
 	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
 		log.Error(err)
-		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	log.Info("Query ended")
@@ -133,12 +160,12 @@ func (c *ExpenseController) UpdateExpense(ctx fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
-	if payload.ArsAmount == 0 || payload.UsdAmount == 0 {
-		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "ARS and USD have to be greater than 0"})
-	}
-
 	response, err := c.expenseService.UpdateExpense(ctx.Context(), userID, expenseID, &payload)
 	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
 		log.Error(err)
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -170,3 +197,58 @@ func (c *ExpenseController) DeleteExpense(ctx fiber.Ctx) error {
 
 	return ctx.Status(fiber.StatusNoContent).Send(nil)
 }
+
+func (c *ExpenseController) GetExpenseSyncs(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	expenseIDStr := ctx.Params("id")
+	expenseID, err := uuid.Parse(expenseIDStr)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid expense ID"})
+	}
+
+	syncs, err := c.expenseService.GetExpenseSyncs(ctx.Context(), userID, expenseID)
+	if err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(syncs)
+}
+
+func (c *ExpenseController) RevalueExpenses(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	var payload RevaluationPayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	result, err := c.revaluationService.Revalue(ctx.Context(), userID, &revaluation.Options{
+		StartDate:   payload.StartDate,
+		EndDate:     payload.EndDate,
+		OnlyInvalid: payload.OnlyInvalid,
+		DryRun:      payload.DryRun,
+	})
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !payload.DryRun {
+		log.Infof("Revalued %d expenses", len(result.Changes))
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
@@ -2,24 +2,35 @@ package expense
 
 import (
 	"context"
+	stdErrors "errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	expensesync "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/expenseSync"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type ExpenseService struct {
-	categoryRepo            *repository.CategoryRepository
-	subcategoryRepo         *repository.SubcategoryRepository
-	paymentMethodRepo       *repository.PaymentMethodRepository
-	recurrentExpenseRepo    *repository.RecurrentExpenseRepository
-	expenseRepo             *repository.ExpenseRepository
-	installmentExpenseRepo  *repository.InstallmentExpenseRepository
-	dollarService           *dollar.DollarService
-	db                      *database.DatabaseService
+	categoryRepo           *repository.CategoryRepository
+	subcategoryRepo        *repository.SubcategoryRepository
+	paymentMethodRepo      *repository.PaymentMethodRepository
+	recurrentExpenseRepo   *repository.RecurrentExpenseRepository
+	expenseRepo            *repository.ExpenseRepository
+	installmentExpenseRepo *repository.InstallmentExpenseRepository
+	expenseSyncRepo        *repository.ExpenseSyncRepository
+	userPreferenceRepo     *repository.UserPreferenceRepository
+	currencyRepo           *repository.CurrencyRepository
+	expenseSyncWorker      *expensesync.ExpenseSyncWorker
+	dollarService          *dollar.DollarService
+	db                     *database.DatabaseService
 }
 
 type ExpenseInsertInformationResponse struct {
@@ -27,19 +38,33 @@ type ExpenseInsertInformationResponse struct {
 	Subcategories     []database.Subcategory      `json:"subcategories"`
 	PaymentMethods    []database.PaymentMethod    `json:"paymentMethods"`
 	RecurrentExpenses []database.RecurrentExpense `json:"recurrentExpenses"`
+	Currencies        []database.Currency         `json:"currencies"`
 	UsdArsFx          float64                     `json:"usdArsFx"`
+	// The rate providers are failing and UsdArsFx is the last known rate
+	UsdArsFxStale bool `json:"usdArsFxStale"`
+	// Timezone of the user, the days of the recurrent expenses are in it
+	Timezone string `json:"timezone"`
 }
 
 type ExpensePayload struct {
-	Description        string  `json:"description" validate:"required"`
-	PaymentMethodID    string  `json:"paymentMethodId" validate:"required,uuid"`
-	ArsAmount          float64 `json:"arsAmount" validate:"required,min=0"`
-	UsdAmount          float64 `json:"usdAmount" validate:"required,min=0"`
-	CategoryID         string  `json:"categoryId" validate:"required,uuid"`
-	SubcategoryID      *string `json:"subcategoryId,omitempty" validate:"omitempty,uuid"`
-	RecurrentExpenseID *string `json:"recurrentExpenseId,omitempty" validate:"omitempty,uuid"`
-	Date               string  `json:"date" validate:"required,datetime=2006-01-02"`
-	InstallmentMonths  int     `json:"installmentMonths,omitempty" validate:"omitempty,min=1"`
+	Description string `json:"description" validate:"required"`
+	// Optional, defaults to the payment method in the user preferences
+	PaymentMethodID string `json:"paymentMethodId" validate:"omitempty,uuid"`
+	// Amount in Currency, or ArsAmount and UsdAmount of an expense paid in ARS. A
+	// missing amount is converted with the rate of the date
+	Amount money.Money `json:"amount,omitempty"`
+	// Optional, defaults to the currency in the user preferences
+	Currency           string      `json:"currency,omitempty"`
+	ArsAmount          money.Money `json:"arsAmount"`
+	UsdAmount          money.Money `json:"usdAmount"`
+	CategoryID         string      `json:"categoryId" validate:"required,uuid"`
+	SubcategoryID      *string     `json:"subcategoryId,omitempty" validate:"omitempty,uuid"`
+	RecurrentExpenseID *string     `json:"recurrentExpenseId,omitempty" validate:"omitempty,uuid"`
+	// YYYY-MM-DD, the date format of the user or RFC3339
+	Date string `json:"date" validate:"required"`
+	// Optional IANA timezone or UTC offset where the expense happened, defaults to the one of the user
+	Timezone          string `json:"timezone,omitempty"`
+	InstallmentMonths int    `json:"installmentMonths,omitempty" validate:"omitempty,min=1"`
 }
 
 // ExpenseWithStringIDs is an internal representation used between controller and service
@@ -47,8 +72,8 @@ type ExpenseWithStringIDs struct {
 	UserID          uuid.UUID
 	Description     string
 	PaymentMethodID string
-	ARSAmount       float64
-	USDAmount       float64
+	ARSAmount       money.Money
+	USDAmount       money.Money
 	CategoryID      string
 	SubcategoryID   *string
 	Date            time.Time
@@ -61,6 +86,10 @@ func NewExpenseService(
 	recurrentExpenseRepo *repository.RecurrentExpenseRepository,
 	expenseRepo *repository.ExpenseRepository,
 	installmentExpenseRepo *repository.InstallmentExpenseRepository,
+	expenseSyncRepo *repository.ExpenseSyncRepository,
+	userPreferenceRepo *repository.UserPreferenceRepository,
+	currencyRepo *repository.CurrencyRepository,
+	expenseSyncWorker *expensesync.ExpenseSyncWorker,
 	dollarService *dollar.DollarService,
 	db *database.DatabaseService,
 ) *ExpenseService {
@@ -71,6 +100,10 @@ func NewExpenseService(
 		recurrentExpenseRepo:   recurrentExpenseRepo,
 		expenseRepo:            expenseRepo,
 		installmentExpenseRepo: installmentExpenseRepo,
+		expenseSyncRepo:        expenseSyncRepo,
+		userPreferenceRepo:     userPreferenceRepo,
+		currencyRepo:           currencyRepo,
+		expenseSyncWorker:      expenseSyncWorker,
 		dollarService:          dollarService,
 		db:                     db,
 	}
@@ -109,7 +142,19 @@ func (s *ExpenseService) GetExpenseInsertInformation(
 		}
 	}
 
-	usdArsFx, err := s.dollarService.GetExchangeRate()
+	currencies, err := s.currencyRepo.GetAll(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := s.dollarService.GetQuote(ctx, preference.RateType)
 
 	if err != nil {
 		return nil, err
@@ -120,56 +165,428 @@ func (s *ExpenseService) GetExpenseInsertInformation(
 		Categories:        categories,
 		Subcategories:     subcategories,
 		RecurrentExpenses: recurrentExpenses,
-		UsdArsFx:          usdArsFx,
+		Currencies:        currencies,
+		UsdArsFx:          quote.Rate,
+		UsdArsFxStale:     quote.Stale,
+		Timezone:          preference.Timezone,
 	}, nil
 }
 
-func (s *ExpenseService) AddExpense(ctx context.Context, userID uuid.UUID, payload *ExpensePayload) (*database.Expense, error) {
-	buenosAiresLoc, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	expenseDate, _ := time.ParseInLocation("2006-01-02", payload.Date, buenosAiresLoc)
+// Values of a payload that depend on the preferences of the user
+type resolvedPayload struct {
+	Date     time.Time
+	Timezone string
+	Amount   *database.ExpenseAmount
+}
+
+/*
+Parses the date of the payload with the timezone and date format of the user
+and sets the payment method to the default one of the user when it is missing.
+The amount is converted to ARS and USD with the rate of the date.
+*/
+func (s *ExpenseService) applyPreferences(ctx context.Context, userID uuid.UUID, payload *ExpensePayload) (*resolvedPayload, error) {
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	if payload.PaymentMethodID == "" {
+		if preference.DefaultPaymentMethodID == nil {
+			return nil, &errors.ValidationError{
+				Field:   "paymentMethodId",
+				Message: "paymentMethodId is required when there is no default payment method",
+				Code:    int32(errors.InvalidPaymentMethod),
+			}
+		}
+		payload.PaymentMethodID = preference.DefaultPaymentMethodID.String()
+	}
+
+	date, timezone, err := dates.ParseExpenseDate(payload.Date, payload.Timezone, preference.Timezone, preference.DateLayout())
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   "date",
+			Message: err.Error(),
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	amount, err := s.parseAmount(ctx, userID, payload, preference, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resolvedPayload{Date: date, Timezone: timezone, Amount: amount}, nil
+}
+
+/*
+The payload has either amount, in currency (the default one of the user if it
+is empty), or the ARS and USD amounts of an expense paid in ARS. When only one
+of these is set the other one is converted with the rate of the date.
+*/
+func (s *ExpenseService) parseAmount(ctx context.Context, userID uuid.UUID, payload *ExpensePayload, preference *database.UserPreference, date time.Time) (*database.ExpenseAmount, error) {
+	var currency string
+	var amount money.Money
+
+	amounts := []struct {
+		field  string
+		amount money.Money
+	}{
+		{"amount", payload.Amount},
+		{"arsAmount", payload.ArsAmount},
+		{"usdAmount", payload.UsdAmount},
+	}
+	for _, a := range amounts {
+		if a.amount.IsNegative() {
+			return nil, &errors.ValidationError{
+				Field:   a.field,
+				Message: fmt.Sprintf("%s cannot be negative", a.field),
+				Code:    int32(errors.InvalidPayload),
+			}
+		}
+	}
+
+	switch {
+	case !payload.Amount.IsZero() && (!payload.ArsAmount.IsZero() || !payload.UsdAmount.IsZero()):
+		return nil, &errors.ValidationError{
+			Field:   "amount",
+			Message: "amount cannot be combined with arsAmount and usdAmount",
+			Code:    int32(errors.InvalidPayload),
+		}
+	case !payload.Amount.IsZero():
+		currency = strings.ToUpper(payload.Currency)
+		if currency == "" {
+			currency = preference.DefaultCurrency
+		}
+		amount = payload.Amount
+	case !payload.ArsAmount.IsZero() && !payload.UsdAmount.IsZero():
+		return database.ARSExpenseAmount(payload.ArsAmount, payload.UsdAmount, database.RateProvider_User), nil
+	case !payload.ArsAmount.IsZero():
+		currency = "ARS"
+		amount = payload.ArsAmount
+	case !payload.UsdAmount.IsZero():
+		currency = "USD"
+		amount = payload.UsdAmount
+	default:
+		return nil, &errors.ValidationError{
+			Field:   "amount",
+			Message: "amount, arsAmount or usdAmount has to be greater than 0",
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	exists, err := s.currencyRepo.Exists(ctx, currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check currency: %w", err)
+	}
+	if !exists {
+		return nil, &errors.ValidationError{
+			Field:   "currency",
+			Message: fmt.Sprintf("unsupported currency: %s", currency),
+			Code:    int32(errors.InvalidCurrency),
+		}
+	}
+
+	paymentMethodID, err := uuid.Parse(payload.PaymentMethodID)
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   "paymentMethodId",
+			Message: "invalid paymentMethodId",
+			Code:    int32(errors.InvalidPaymentMethod),
+		}
+	}
+
+	// The payment method can override the rate type of the user
+	rateType := preference.RateType
+	paymentMethodRateType, err := s.paymentMethodRepo.GetRateType(ctx, paymentMethodID, userID)
+	if stdErrors.Is(err, pgx.ErrNoRows) {
+		return nil, &errors.ValidationError{
+			Field:   "paymentMethodId",
+			Message: "payment method not found",
+			Code:    int32(errors.InvalidPaymentMethod),
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment method rate type: %w", err)
+	}
+	if paymentMethodRateType != nil {
+		rateType = *paymentMethodRateType
+	}
+
+	return s.dollarService.Convert(ctx, currency, amount, rateType, date)
+}
+
+/*
+If idempotencyKey is not nil and an expense was already created with it,
+that expense is returned and nothing is inserted.
+*/
+func (s *ExpenseService) AddExpense(ctx context.Context, userID uuid.UUID, payload *ExpensePayload, idempotencyKey *string) (*database.Expense, error) {
+	if idempotencyKey != nil {
+		existing, err := s.GetByIdempotencyKey(ctx, userID, *idempotencyKey)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	resolved, err := s.applyPreferences(ctx, userID, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	expense, err := s.expenseRepo.InsertFromStrings(
+	expense, err := s.expenseRepo.InsertFromStringsWithTx(
 		ctx,
+		tx,
 		userID,
 		payload.Description,
 		payload.PaymentMethodID,
-		payload.ArsAmount,
-		payload.UsdAmount,
+		resolved.Amount,
 		payload.CategoryID,
 		payload.SubcategoryID,
 		payload.RecurrentExpenseID,
-		expenseDate,
+		resolved.Date,
+		resolved.Timezone,
+		idempotencyKey,
 	)
 	if err != nil {
+		// A concurrent request with the same key inserted the expense first
+		if repository.IsIdempotencyKeyConflict(err) {
+			return s.GetByIdempotencyKey(ctx, userID, *idempotencyKey)
+		}
+		return nil, fmt.Errorf("failed to insert expense: %w", err)
+	}
+
+	if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, userID, expense.ID, database.SyncOperation_Insert); err != nil {
+		return nil, fmt.Errorf("failed to insert expense sync: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.expenseSyncWorker.Notify()
+
+	return expense, nil
+}
+
+/*
+Inserts an expense whose references were already resolved, like the ones
+built by ExpenseValidatorService, and schedules its sync. If the expense has
+an idempotency key that was already used, the original expense is returned.
+*/
+func (s *ExpenseService) InsertExpense(ctx context.Context, expense *database.Expense) (*database.Expense, error) {
+	if expense.IdempotencyKey != nil {
+		existing, err := s.GetByIdempotencyKey(ctx, expense.UserID, *expense.IdempotencyKey)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := s.expenseRepo.InsertWithTx(ctx, tx, expense, expense.RecurrentExpenseID, nil)
+	if err != nil {
+		// A concurrent request with the same key inserted the expense first
+		if repository.IsIdempotencyKeyConflict(err) {
+			return s.GetByIdempotencyKey(ctx, expense.UserID, *expense.IdempotencyKey)
+		}
 		return nil, fmt.Errorf("failed to insert expense: %w", err)
 	}
 
+	if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, expense.UserID, id, database.SyncOperation_Insert); err != nil {
+		return nil, fmt.Errorf("failed to insert expense sync: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.expenseSyncWorker.Notify()
+
+	expense.ID = id
+	return expense, nil
+}
+
+/*
+Same as InsertExpense for many expenses of a user, all in one transaction.
+Returns the ID of each expense in the same order. Expenses whose idempotency
+key was already used, before or earlier in the same call, get the ID of the
+original expense instead of being inserted again.
+*/
+func (s *ExpenseService) InsertExpenses(ctx context.Context, userID uuid.UUID, expenses []*database.Expense) ([]uuid.UUID, error) {
+	var keys []string
+	for _, expense := range expenses {
+		if expense.IdempotencyKey != nil {
+			keys = append(keys, *expense.IdempotencyKey)
+		}
+	}
+
+	usedKeys := make(map[string]uuid.UUID)
+	if len(keys) > 0 {
+		existing, err := s.GetIDsByIdempotencyKeys(ctx, userID, keys)
+		if err != nil {
+			return nil, err
+		}
+		for key, ids := range existing {
+			usedKeys[key] = ids[0]
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]uuid.UUID, len(expenses))
+	var insertedIDs []uuid.UUID
+
+	for i, expense := range expenses {
+		if expense.IdempotencyKey != nil {
+			if id, ok := usedKeys[*expense.IdempotencyKey]; ok {
+				ids[i] = id
+				continue
+			}
+		}
+
+		id, inserted, err := s.insertWithSavepoint(ctx, tx, expense)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert expense %d: %w", i, err)
+		}
+
+		ids[i] = id
+		if expense.IdempotencyKey != nil {
+			usedKeys[*expense.IdempotencyKey] = id
+		}
+		if inserted {
+			insertedIDs = append(insertedIDs, id)
+		}
+	}
+
+	if len(insertedIDs) > 0 {
+		if err := s.expenseSyncRepo.InsertManyForUserDestinationsWithTx(ctx, tx, userID, insertedIDs, database.SyncOperation_Insert); err != nil {
+			return nil, fmt.Errorf("failed to insert expense syncs: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if len(insertedIDs) > 0 {
+		s.expenseSyncWorker.Notify()
+	}
+
+	return ids, nil
+}
+
+/*
+Inserts the expense inside a savepoint so an idempotency key conflict with a
+concurrent request does not abort the whole transaction. In that case the ID
+of the expense that won is returned with inserted set to false.
+*/
+func (s *ExpenseService) insertWithSavepoint(ctx context.Context, tx pgx.Tx, expense *database.Expense) (id uuid.UUID, inserted bool, err error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer savepoint.Rollback(ctx)
+
+	id, err = s.expenseRepo.InsertWithTx(ctx, savepoint, expense, expense.RecurrentExpenseID, nil)
+	if err != nil {
+		if !repository.IsIdempotencyKeyConflict(err) {
+			return uuid.Nil, false, err
+		}
+
+		if err := savepoint.Rollback(ctx); err != nil {
+			return uuid.Nil, false, err
+		}
+
+		existing, err := s.expenseRepo.GetByIdempotencyKey(ctx, expense.UserID, *expense.IdempotencyKey)
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+
+		return existing.ID, false, nil
+	}
+
+	if err := savepoint.Commit(ctx); err != nil {
+		return uuid.Nil, false, err
+	}
+
+	return id, true, nil
+}
+
+// Returns the IDs of the expenses created with any of the keys, by key. All the installments of installment purchases are included
+func (s *ExpenseService) GetIDsByIdempotencyKeys(ctx context.Context, userID uuid.UUID, idempotencyKeys []string) (map[string][]uuid.UUID, error) {
+	ids, err := s.expenseRepo.GetIDsByIdempotencyKeys(ctx, userID, idempotencyKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expenses by idempotency key: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Returns nil if the user has no expense created with the key
+func (s *ExpenseService) GetByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey string) (*database.Expense, error) {
+	expense, err := s.expenseRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey)
+	if err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to fetch expense by idempotency key: %w", err)
+	}
+
 	return expense, nil
 }
 
 func (s *ExpenseService) GetExpenses(ctx context.Context, userID uuid.UUID, startDateStr string, endDateStr string, categoryID *uuid.UUID, subcategoryID *uuid.UUID) ([]database.Expense, error) {
-	buenosAiresLoc, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
 
+	// Only the calendar day is used, each expense is compared in its own timezone
 	var startDate *time.Time
 	if startDateStr != "" {
-		t, err := time.ParseInLocation("2006-01-02", startDateStr, buenosAiresLoc)
+		t, err := dates.ParseDate(startDateStr, preference.DateLayout(), time.UTC)
 		if err != nil {
-			return nil, fmt.Errorf("invalid startDate format, expected YYYY-MM-DD: %w", err)
+			return nil, &errors.ValidationError{
+				Field:   "startDate",
+				Message: fmt.Sprintf("invalid startDate format, expected YYYY-MM-DD or %s", preference.DateFormat),
+				Code:    int32(errors.InvalidDate),
+			}
 		}
 		startDate = &t
 	}
 
 	var endDate *time.Time
 	if endDateStr != "" {
-		t, err := time.ParseInLocation("2006-01-02", endDateStr, buenosAiresLoc)
+		t, err := dates.ParseDate(endDateStr, preference.DateLayout(), time.UTC)
 		if err != nil {
-			return nil, fmt.Errorf("invalid endDate format, expected YYYY-MM-DD: %w", err)
+			return nil, &errors.ValidationError{
+				Field:   "endDate",
+				Message: fmt.Sprintf("invalid endDate format, expected YYYY-MM-DD or %s", preference.DateFormat),
+				Code:    int32(errors.InvalidDate),
+			}
 		}
 		endDate = &t
 	}
 
 	if startDate != nil && endDate != nil && startDate.After(*endDate) {
-		return nil, fmt.Errorf("startDate cannot be after endDate")
+		return nil, &errors.ValidationError{
+			Field:   "startDate",
+			Message: "startDate cannot be after endDate",
+			Code:    int32(errors.InvalidDate),
+		}
 	}
 
 	expenses, err := s.expenseRepo.GetByUserIDAndDateRange(ctx, userID, startDate, endDate, categoryID, subcategoryID)
@@ -180,55 +597,112 @@ func (s *ExpenseService) GetExpenses(ctx context.Context, userID uuid.UUID, star
 	return expenses, nil
 }
 
+func (s *ExpenseService) GetExpense(ctx context.Context, userID uuid.UUID, expenseID uuid.UUID) (*database.Expense, error) {
+	expense, err := s.expenseRepo.GetByID(ctx, expenseID, userID)
+	if err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("expense not found: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch expense: %w", err)
+	}
+
+	return expense, nil
+}
+
 func (s *ExpenseService) UpdateExpense(ctx context.Context, userID uuid.UUID, expenseID uuid.UUID, payload *ExpensePayload) (*database.Expense, error) {
-	// Verify the expense exists and belongs to the user
-	_, err := s.expenseRepo.GetByID(ctx, expenseID, userID)
+	resolved, err := s.applyPreferences(ctx, userID, payload)
 	if err != nil {
-		return nil, fmt.Errorf("expense not found: %w", err)
+		return nil, err
 	}
 
-	buenosAiresLoc, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	expenseDate, _ := time.ParseInLocation("2006-01-02", payload.Date, buenosAiresLoc)
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	expense, err := s.expenseRepo.Update(
+	// Fails with pgx.ErrNoRows if the expense does not exist or does not belong to the user
+	err = s.expenseRepo.UpdateWithTx(
 		ctx,
+		tx,
 		expenseID,
 		userID,
 		payload.Description,
 		payload.PaymentMethodID,
-		payload.ArsAmount,
-		payload.UsdAmount,
+		resolved.Amount,
 		payload.CategoryID,
 		payload.SubcategoryID,
 		payload.RecurrentExpenseID,
-		expenseDate,
+		resolved.Date,
+		resolved.Timezone,
 	)
 	if err != nil {
+		if stdErrors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("expense not found: %w", err)
+		}
 		return nil, fmt.Errorf("failed to update expense: %w", err)
 	}
 
+	if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, userID, expenseID, database.SyncOperation_Update); err != nil {
+		return nil, fmt.Errorf("failed to insert expense sync: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.expenseSyncWorker.Notify()
+
+	expense, err := s.expenseRepo.GetByID(ctx, expenseID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch updated expense: %w", err)
+	}
+
 	return expense, nil
 }
 
 func (s *ExpenseService) DeleteExpense(ctx context.Context, userID uuid.UUID, expenseID uuid.UUID) error {
-	err := s.expenseRepo.Delete(ctx, expenseID, userID)
+	tx, err := s.db.BeginTx(ctx)
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.expenseRepo.DeleteWithTx(ctx, tx, expenseID, userID); err != nil {
 		return fmt.Errorf("failed to delete expense: %w", err)
 	}
 
+	if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, userID, expenseID, database.SyncOperation_Delete); err != nil {
+		return fmt.Errorf("failed to insert expense sync: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.expenseSyncWorker.Notify()
+
 	return nil
 }
 
-func (s *ExpenseService) AddInstallmentExpense(ctx context.Context, userID uuid.UUID, payload *ExpensePayload) ([]uuid.UUID, error) {
-	if payload.InstallmentMonths < 1 {
-		return nil, fmt.Errorf("installmentMonths must be at least 1")
+func (s *ExpenseService) GetExpenseSyncs(ctx context.Context, userID uuid.UUID, expenseID uuid.UUID) ([]database.ExpenseSync, error) {
+	syncs, err := s.expenseSyncRepo.GetByExpenseID(ctx, expenseID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expense syncs: %w", err)
 	}
 
-	buenosAiresLoc, _ := time.LoadLocation("America/Argentina/Buenos_Aires")
-	startDate, _ := time.ParseInLocation("2006-01-02", payload.Date, buenosAiresLoc)
+	return syncs, nil
+}
 
-	paymentMethodUUID := uuid.MustParse(payload.PaymentMethodID)
-	categoryUUID := uuid.MustParse(payload.CategoryID)
+/*
+The idempotency key is stored in the first installment. Replaying it
+returns the IDs of every installment of the original purchase.
+*/
+func (s *ExpenseService) AddInstallmentExpense(ctx context.Context, userID uuid.UUID, payload *ExpensePayload, idempotencyKey *string) ([]uuid.UUID, error) {
+	resolved, err := s.applyPreferences(ctx, userID, payload)
+	if err != nil {
+		return nil, err
+	}
 
 	var subcategoryUUID *uuid.UUID
 	if payload.SubcategoryID != nil {
@@ -236,22 +710,64 @@ func (s *ExpenseService) AddInstallmentExpense(ctx context.Context, userID uuid.
 		subcategoryUUID = &parsed
 	}
 
+	purchase := &database.Expense{
+		UserID:          userID,
+		Description:     payload.Description,
+		PaymentMethodID: uuid.MustParse(payload.PaymentMethodID),
+		CategoryID:      uuid.MustParse(payload.CategoryID),
+		SubcategoryID:   subcategoryUUID,
+		Date:            resolved.Date,
+		Timezone:        resolved.Timezone,
+		IdempotencyKey:  idempotencyKey,
+	}
+	purchase.SetAmount(resolved.Amount)
+
+	return s.InsertInstallmentExpense(ctx, purchase, payload.InstallmentMonths)
+}
+
+/*
+Splits a purchase into one expense per month, starting at the date of the
+purchase, linked by an installment expense. purchase has the total amounts,
+which are divided evenly with the cents left over on the first installment
+so they add up to the total, and its idempotency key is stored in the first
+installment. Returns the IDs of the installments in order.
+*/
+func (s *ExpenseService) InsertInstallmentExpense(ctx context.Context, purchase *database.Expense, months int) ([]uuid.UUID, error) {
+	if months < 1 {
+		return nil, fmt.Errorf("installmentMonths must be at least 1")
+	}
+
+	userID := purchase.UserID
+	idempotencyKey := purchase.IdempotencyKey
+
+	if idempotencyKey != nil {
+		expenseIDs, err := s.GetExpenseIDsByIdempotencyKey(ctx, userID, *idempotencyKey)
+		if err != nil || expenseIDs != nil {
+			return expenseIDs, err
+		}
+	}
+
+	startDate := dates.In(purchase.Date, purchase.Timezone)
+
 	tx, err := s.db.BeginTx(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	installmentID, err := s.installmentExpenseRepo.InsertWithTx(ctx, tx, userID, payload.Description)
+	installmentID, err := s.installmentExpenseRepo.InsertWithTx(ctx, tx, userID, purchase.Description)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert installment expense: %w", err)
 	}
 
-	arsPerInstallment := payload.ArsAmount / float64(payload.InstallmentMonths)
-	usdPerInstallment := payload.UsdAmount / float64(payload.InstallmentMonths)
+	// Rates are the ones of the purchase
+	total := purchase.Amount()
+	originalAmounts := total.OriginalAmount.Split(months)
+	arsAmounts := total.ARSAmount.Split(months)
+	usdAmounts := total.USDAmount.Split(months)
 
-	expenseIDs := make([]uuid.UUID, payload.InstallmentMonths)
-	for i := 0; i < payload.InstallmentMonths; i++ {
+	expenseIDs := make([]uuid.UUID, months)
+	for i := 0; i < months; i++ {
 		targetYear := startDate.Year()
 		targetMonth := startDate.Month() + time.Month(i)
 
@@ -268,30 +784,71 @@ func (s *ExpenseService) AddInstallmentExpense(ctx context.Context, userID uuid.
 			day = lastDayOfMonth
 		}
 
-		installmentDate := time.Date(targetYear, targetMonth, day, 0, 0, 0, 0, startDate.Location())
+		// Keeps the time of day of the purchase
+		installmentDate := time.Date(targetYear, targetMonth, day, startDate.Hour(), startDate.Minute(), startDate.Second(), startDate.Nanosecond(), startDate.Location())
 
 		expense := &database.Expense{
 			UserID:          userID,
-			Description:     fmt.Sprintf("%s (%d/%d)", payload.Description, i+1, payload.InstallmentMonths),
-			PaymentMethodID: paymentMethodUUID,
-			ARSAmount:       arsPerInstallment,
-			USDAmount:       usdPerInstallment,
-			CategoryID:      categoryUUID,
-			SubcategoryID:   subcategoryUUID,
+			Description:     fmt.Sprintf("%s (%d/%d)", purchase.Description, i+1, months),
+			PaymentMethodID: purchase.PaymentMethodID,
+			CategoryID:      purchase.CategoryID,
+			SubcategoryID:   purchase.SubcategoryID,
 			Date:            installmentDate,
+			Timezone:        purchase.Timezone,
+		}
+		installmentAmount := *total
+		installmentAmount.OriginalAmount = originalAmounts[i]
+		installmentAmount.ARSAmount = arsAmounts[i]
+		installmentAmount.USDAmount = usdAmounts[i]
+		expense.SetAmount(&installmentAmount)
+
+		if i == 0 {
+			expense.IdempotencyKey = idempotencyKey
 		}
 
 		id, err := s.expenseRepo.InsertWithTx(ctx, tx, expense, nil, &installmentID)
 		if err != nil {
+			// A concurrent request with the same key inserted the installments first
+			if repository.IsIdempotencyKeyConflict(err) {
+				return s.GetExpenseIDsByIdempotencyKey(ctx, userID, *idempotencyKey)
+			}
 			return nil, fmt.Errorf("failed to insert expense %d: %w", i+1, err)
 		}
 		expenseIDs[i] = id
+
+		if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, userID, id, database.SyncOperation_Insert); err != nil {
+			return nil, fmt.Errorf("failed to insert expense sync %d: %w", i+1, err)
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	s.expenseSyncWorker.Notify()
+
 	return expenseIDs, nil
 }
 
+/*
+Returns the IDs of the expenses created with the key, all the installments if
+it was an installment purchase. Returns nil if the user has no expense created
+with the key.
+*/
+func (s *ExpenseService) GetExpenseIDsByIdempotencyKey(ctx context.Context, userID uuid.UUID, idempotencyKey string) ([]uuid.UUID, error) {
+	existing, err := s.GetByIdempotencyKey(ctx, userID, idempotencyKey)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	if existing.InstallementsExpenseID == nil {
+		return []uuid.UUID{existing.ID}, nil
+	}
+
+	expenseIDs, err := s.expenseRepo.GetIDsByInstallmentExpenseID(ctx, *existing.InstallementsExpenseID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch installment expenses: %w", err)
+	}
+
+	return expenseIDs, nil
+}
@@ -0,0 +1,49 @@
+package fx
+
+import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+)
+
+type FxController struct {
+	fxService *FxService
+}
+
+func NewFxController(fxService *FxService) *FxController {
+	return &FxController{
+		fxService: fxService,
+	}
+}
+
+// Current rates with the prices they were computed from. Optional type query parameter
+func (c *FxController) GetCurrent(ctx fiber.Ctx) error {
+	rates, err := c.fxService.GetCurrent(ctx.Context(), ctx.Query("type"))
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(rates)
+}
+
+// Stored daily rates. Optional from, to (YYYY-MM-DD) and type query parameters
+func (c *FxController) GetHistory(ctx fiber.Ctx) error {
+	rates, err := c.fxService.GetHistory(ctx.Context(), ctx.Query("from"), ctx.Query("to"), ctx.Query("type"))
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(rates)
+}
@@ -0,0 +1,160 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+)
+
+// Days returned by the history when from is missing
+const defaultHistoryDays = 30
+
+type FxService struct {
+	dollarService    *dollar.DollarService
+	exchangeRateRepo *repository.ExchangeRateRepository
+}
+
+type CurrentRate struct {
+	RateType database.RateType `json:"rateType"`
+	Rate     float64           `json:"rate"`
+	Provider string            `json:"provider"`
+	// Prices the rate was computed from, e.g. AL30 and AL30D for MEP
+	Components map[string]float64 `json:"components"`
+	FetchedAt  time.Time          `json:"fetchedAt"`
+	// Seconds since the rate was fetched
+	CacheAge float64 `json:"cacheAge"`
+	// The providers failed and this is the last known rate
+	Stale bool `json:"stale"`
+	// Set when there is no rate of the type at all
+	Error string `json:"error,omitempty"`
+}
+
+func NewFxService(dollarService *dollar.DollarService, exchangeRateRepo *repository.ExchangeRateRepository) *FxService {
+	return &FxService{
+		dollarService:    dollarService,
+		exchangeRateRepo: exchangeRateRepo,
+	}
+}
+
+/*
+Cached rate of every rate type, or only of rateTypeStr if it is not empty.
+The rates are not refreshed, CacheAge tells how old they are.
+*/
+func (s *FxService) GetCurrent(ctx context.Context, rateTypeStr string) ([]CurrentRate, error) {
+	rateTypes := s.dollarService.RateTypes()
+	if rateTypeStr != "" {
+		rateType, err := s.parseRateType(rateTypeStr)
+		if err != nil {
+			return nil, err
+		}
+		rateTypes = []database.RateType{rateType}
+	}
+
+	rates := make([]CurrentRate, 0, len(rateTypes))
+
+	for _, rateType := range rateTypes {
+		quote, err := s.dollarService.CachedQuote(ctx, rateType)
+		if err != nil {
+			rates = append(rates, CurrentRate{RateType: rateType, Error: err.Error()})
+			continue
+		}
+
+		rates = append(rates, CurrentRate{
+			RateType:   quote.Type,
+			Rate:       quote.Rate,
+			Provider:   quote.Provider,
+			Components: quote.Components,
+			FetchedAt:  quote.FetchedAt,
+			CacheAge:   time.Since(quote.FetchedAt).Seconds(),
+			Stale:      quote.Stale,
+		})
+	}
+
+	return rates, nil
+}
+
+/*
+Stored rates between from and to (YYYY-MM-DD, Buenos Aires days), both
+included. to defaults to today and from to defaultHistoryDays before to.
+Without rateTypeStr the rates of all types are returned.
+*/
+func (s *FxService) GetHistory(ctx context.Context, fromStr string, toStr string, rateTypeStr string) ([]database.ExchangeRate, error) {
+	var rateType *database.RateType
+	if rateTypeStr != "" {
+		parsed, err := s.parseRateType(rateTypeStr)
+		if err != nil {
+			return nil, err
+		}
+		rateType = &parsed
+	}
+
+	today := dates.In(time.Now(), dates.DefaultTimezone)
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if toStr != "" {
+		parsed, err := parseDay("to", toStr)
+		if err != nil {
+			return nil, err
+		}
+		to = parsed
+	}
+
+	from := to.AddDate(0, 0, -defaultHistoryDays)
+	if fromStr != "" {
+		parsed, err := parseDay("from", fromStr)
+		if err != nil {
+			return nil, err
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		return nil, &errors.ValidationError{
+			Field:   "from",
+			Message: "from cannot be after to",
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	rates, err := s.exchangeRateRepo.GetRange(ctx, rateType, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+
+	return rates, nil
+}
+
+func (s *FxService) parseRateType(value string) (database.RateType, error) {
+	rateType := database.RateType(value)
+
+	for _, known := range s.dollarService.RateTypes() {
+		if known == rateType {
+			return rateType, nil
+		}
+	}
+
+	return "", &errors.ValidationError{
+		Field:   "type",
+		Message: fmt.Sprintf("type has to be mep, ccl, official or card, found %s", value),
+		Code:    int32(errors.InvalidPayload),
+	}
+}
+
+// Exchange rates are stored by day as UTC midnight
+func parseDay(field string, value string) (time.Time, error) {
+	day, err := dates.ParseDate(value, dates.DateLayout, time.UTC)
+	if err != nil {
+		return time.Time{}, &errors.ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid %s format, expected YYYY-MM-DD", field),
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	return day, nil
+}
@@ -1,6 +1,9 @@
 package paymentmethod
 
 import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/log"
@@ -50,6 +53,10 @@ func (c *PaymentMethodController) AddPaymentMethod(ctx fiber.Ctx) error {
 
 	pm, err := c.paymentMethodService.Insert(ctx.Context(), userID, &payload)
 	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
 		log.Error(err)
 		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -83,6 +90,10 @@ func (c *PaymentMethodController) UpdatePaymentMethod(ctx fiber.Ctx) error {
 
 	pm, err := c.paymentMethodService.Update(ctx.Context(), id, userID, &payload)
 	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
 		log.Error(err)
 		if err.Error() == "payment method not found" {
 			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
@@ -6,6 +6,7 @@ import (
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 )
@@ -16,6 +17,8 @@ type PaymentMethodService struct {
 
 type PaymentMethodPayload struct {
 	Name string `json:"name" validate:"required"`
+	// Optional, overrides the rate type of the user preferences
+	RateType *database.RateType `json:"rateType,omitempty"`
 }
 
 func NewPaymentMethodService(paymentMethodRepo *repository.PaymentMethodRepository) *PaymentMethodService {
@@ -29,7 +32,11 @@ func (s *PaymentMethodService) GetByUserID(ctx context.Context, userID uuid.UUID
 }
 
 func (s *PaymentMethodService) Insert(ctx context.Context, userID uuid.UUID, payload *PaymentMethodPayload) (*database.PaymentMethod, error) {
-	pm, err := s.paymentMethodRepo.Insert(ctx, userID, payload.Name)
+	if err := validateRateType(payload.RateType); err != nil {
+		return nil, err
+	}
+
+	pm, err := s.paymentMethodRepo.Insert(ctx, userID, payload.Name, payload.RateType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert payment method: %w", err)
 	}
@@ -38,7 +45,11 @@ func (s *PaymentMethodService) Insert(ctx context.Context, userID uuid.UUID, pay
 }
 
 func (s *PaymentMethodService) Update(ctx context.Context, id uuid.UUID, userID uuid.UUID, payload *PaymentMethodPayload) (*database.PaymentMethod, error) {
-	pm, err := s.paymentMethodRepo.Update(ctx, id, userID, payload.Name)
+	if err := validateRateType(payload.RateType); err != nil {
+		return nil, err
+	}
+
+	pm, err := s.paymentMethodRepo.Update(ctx, id, userID, payload.Name, payload.RateType)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("payment method not found")
@@ -48,3 +59,15 @@ func (s *PaymentMethodService) Update(ctx context.Context, id uuid.UUID, userID
 
 	return pm, nil
 }
+
+func validateRateType(rateType *database.RateType) error {
+	if rateType == nil || rateType.IsValid() {
+		return nil
+	}
+
+	return &errors.ValidationError{
+		Field:   "rateType",
+		Message: fmt.Sprintf("rateType has to be mep, ccl, official or card, found %s", *rateType),
+		Code:    int32(errors.InvalidPayload),
+	}
+}
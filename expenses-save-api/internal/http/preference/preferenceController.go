@@ -0,0 +1,62 @@
+package preference
+
+import (
+	stdErrors "errors"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/log"
+)
+
+type PreferenceController struct {
+	preferenceService *PreferenceService
+}
+
+func NewPreferenceController(preferenceService *PreferenceService) *PreferenceController {
+	return &PreferenceController{
+		preferenceService: preferenceService,
+	}
+}
+
+func (c *PreferenceController) GetPreferences(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	preference, err := c.preferenceService.GetPreferences(ctx.Context(), userID)
+	if err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(preference)
+}
+
+func (c *PreferenceController) UpdatePreferences(ctx fiber.Ctx) error {
+	userID, err := middleware.GetUserIDFromContext(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "user ID not found in context"})
+	}
+
+	var payload PreferencePayload
+	if err := ctx.Bind().Body(&payload); err != nil {
+		log.Error(err)
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	preference, err := c.preferenceService.UpdatePreferences(ctx.Context(), userID, &payload)
+	if err != nil {
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": validationErr.Message, "field": validationErr.Field})
+		}
+		log.Error(err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	log.Info("Updated preferences")
+
+	return ctx.Status(fiber.StatusOK).JSON(preference)
+}
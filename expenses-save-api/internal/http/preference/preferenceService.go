@@ -0,0 +1,119 @@
+package preference
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/google/uuid"
+)
+
+type PreferenceService struct {
+	userPreferenceRepo *repository.UserPreferenceRepository
+	paymentMethodRepo  *repository.PaymentMethodRepository
+	currencyRepo       *repository.CurrencyRepository
+}
+
+// Empty fields keep their current value
+type PreferencePayload struct {
+	Timezone               string              `json:"timezone"`
+	DefaultCurrency        string              `json:"defaultCurrency"`
+	DefaultPaymentMethodID *uuid.UUID          `json:"defaultPaymentMethodId"`
+	DateFormat             database.DateFormat `json:"dateFormat"`
+	RateType               database.RateType   `json:"rateType"`
+}
+
+func NewPreferenceService(userPreferenceRepo *repository.UserPreferenceRepository, paymentMethodRepo *repository.PaymentMethodRepository, currencyRepo *repository.CurrencyRepository) *PreferenceService {
+	return &PreferenceService{
+		userPreferenceRepo: userPreferenceRepo,
+		paymentMethodRepo:  paymentMethodRepo,
+		currencyRepo:       currencyRepo,
+	}
+}
+
+func (s *PreferenceService) GetPreferences(ctx context.Context, userID uuid.UUID) (*database.UserPreference, error) {
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	return preference, nil
+}
+
+// Only the fields set in payload are changed, so a partial update does not reset the rest
+func (s *PreferenceService) UpdatePreferences(ctx context.Context, userID uuid.UUID, payload *PreferencePayload) (*database.UserPreference, error) {
+	// The defaults if the user never saved them
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	if payload.Timezone != "" {
+		if _, err := dates.LoadLocation(payload.Timezone); err != nil {
+			return nil, &errors.ValidationError{Field: "timezone", Message: err.Error(), Code: int32(errors.InvalidPayload)}
+		}
+		preference.Timezone = payload.Timezone
+	}
+
+	if payload.DefaultCurrency != "" {
+		exists, err := s.currencyRepo.Exists(ctx, payload.DefaultCurrency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check currency: %w", err)
+		}
+		if !exists {
+			return nil, &errors.ValidationError{
+				Field:   "defaultCurrency",
+				Message: fmt.Sprintf("unsupported currency: %s", payload.DefaultCurrency),
+				Code:    int32(errors.InvalidCurrency),
+			}
+		}
+		preference.DefaultCurrency = payload.DefaultCurrency
+	}
+
+	if payload.DateFormat != "" {
+		if _, ok := dates.Layout(string(payload.DateFormat)); !ok {
+			return nil, &errors.ValidationError{
+				Field:   "dateFormat",
+				Message: fmt.Sprintf("dateFormat has to be YYYY-MM-DD, DD/MM/YYYY or MM/DD/YYYY, found %s", payload.DateFormat),
+				Code:    int32(errors.InvalidPayload),
+			}
+		}
+		preference.DateFormat = payload.DateFormat
+	}
+
+	if payload.RateType != "" {
+		if !payload.RateType.IsValid() {
+			return nil, &errors.ValidationError{
+				Field:   "rateType",
+				Message: fmt.Sprintf("rateType has to be mep, ccl, official or card, found %s", payload.RateType),
+				Code:    int32(errors.InvalidPayload),
+			}
+		}
+		preference.RateType = payload.RateType
+	}
+
+	if payload.DefaultPaymentMethodID != nil {
+		exists, err := s.paymentMethodRepo.Exists(ctx, *payload.DefaultPaymentMethodID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check payment method: %w", err)
+		}
+		if !exists {
+			return nil, &errors.ValidationError{
+				Field:   "defaultPaymentMethodId",
+				Message: "payment method not found for user",
+				Code:    int32(errors.InvalidPaymentMethod),
+			}
+		}
+		preference.DefaultPaymentMethodID = payload.DefaultPaymentMethodID
+	}
+
+	updated, err := s.userPreferenceRepo.Upsert(ctx, preference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save preferences: %w", err)
+	}
+
+	return updated, nil
+}
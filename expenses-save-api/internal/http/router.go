@@ -5,21 +5,29 @@ import (
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/alias"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/category"
+	categoryrule "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/categoryRule"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/expense"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/fx"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/middleware"
 	paymentmethod "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/paymentMethod"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/http/preference"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
 	"github.com/gofiber/fiber/v3/middleware/logger"
 )
 
 type HttpServer struct {
-	app                    *fiber.App
-	dbService              *database.DatabaseService
-	categoryController     *category.CategoryController
-	expenseController      *expense.ExpenseController
+	app                     *fiber.App
+	dbService               *database.DatabaseService
+	categoryController      *category.CategoryController
+	expenseController       *expense.ExpenseController
 	paymentMethodController *paymentmethod.PaymentMethodController
+	categoryRuleController  *categoryrule.CategoryRuleController
+	aliasController         *alias.AliasController
+	preferenceController    *preference.PreferenceController
+	fxController            *fx.FxController
 }
 
 func NewHttpServer(
@@ -27,6 +35,10 @@ func NewHttpServer(
 	categoryController *category.CategoryController,
 	expenseController *expense.ExpenseController,
 	paymentMethodController *paymentmethod.PaymentMethodController,
+	categoryRuleController *categoryrule.CategoryRuleController,
+	aliasController *alias.AliasController,
+	preferenceController *preference.PreferenceController,
+	fxController *fx.FxController,
 ) *HttpServer {
 	app := fiber.New()
 	app.Use(logger.New(logger.Config{
@@ -36,7 +48,7 @@ func NewHttpServer(
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: []string{"*"},
 		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "internal-user-id"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Authorization", "internal-user-id", "Idempotency-Key"},
 	}))
 
 	return &HttpServer{
@@ -45,6 +57,10 @@ func NewHttpServer(
 		categoryController:      categoryController,
 		expenseController:       expenseController,
 		paymentMethodController: paymentMethodController,
+		categoryRuleController:  categoryRuleController,
+		aliasController:         aliasController,
+		preferenceController:    preferenceController,
+		fxController:            fxController,
 	}
 }
 
@@ -66,11 +82,33 @@ func (s *HttpServer) RegisterRouter() {
 	expenseGroup.Get("/", s.expenseController.GetExpenses)
 	expenseGroup.Get("/insertInformation", s.expenseController.GetInsertInformation)
 	expenseGroup.Post("/", s.expenseController.AddExpense)
+	expenseGroup.Post("/revaluation", s.expenseController.RevalueExpenses)
 	expenseGroup.Patch("/:id", s.expenseController.UpdateExpense)
 	expenseGroup.Delete("/:id", s.expenseController.DeleteExpense)
+	expenseGroup.Get("/:id/sync", s.expenseController.GetExpenseSyncs)
 
 	paymentMethodGroup := s.app.Group("/paymentMethod")
 	paymentMethodGroup.Get("/", s.paymentMethodController.GetPaymentMethods)
 	paymentMethodGroup.Post("/", s.paymentMethodController.AddPaymentMethod)
 	paymentMethodGroup.Patch("/:id", s.paymentMethodController.UpdatePaymentMethod)
+
+	categoryRuleGroup := s.app.Group("/categoryRule")
+	categoryRuleGroup.Get("/", s.categoryRuleController.GetRules)
+	categoryRuleGroup.Post("/", s.categoryRuleController.AddRule)
+	categoryRuleGroup.Post("/test", s.categoryRuleController.TestRule)
+	categoryRuleGroup.Patch("/:id", s.categoryRuleController.UpdateRule)
+	categoryRuleGroup.Delete("/:id", s.categoryRuleController.DeleteRule)
+
+	aliasGroup := s.app.Group("/alias")
+	aliasGroup.Get("/", s.aliasController.GetAliases)
+	aliasGroup.Post("/", s.aliasController.AddAlias)
+	aliasGroup.Delete("/:id", s.aliasController.DeleteAlias)
+
+	preferenceGroup := s.app.Group("/preference")
+	preferenceGroup.Get("/", s.preferenceController.GetPreferences)
+	preferenceGroup.Put("/", s.preferenceController.UpdatePreferences)
+
+	fxGroup := s.app.Group("/fx")
+	fxGroup.Get("/current", s.fxController.GetCurrent)
+	fxGroup.Get("/history", s.fxController.GetHistory)
 }
@@ -0,0 +1,256 @@
+package importer
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/validator"
+	"github.com/google/uuid"
+)
+
+/*
+Columns of the historical sheet. It is the layout written by the Google
+Sheets destination without the leading expense ID column.
+*/
+const (
+	columnDate = iota
+	columnDescription
+	columnPaymentMethod
+	columnARS
+	columnUSD
+	columnCategory
+	columnSubcategory
+	columnCount
+)
+
+type Options struct {
+	SheetID   string
+	SheetName string
+	// Skips the first row of the sheet
+	HasHeader bool
+	// Resolves every row without inserting anything
+	DryRun bool
+	// Creates pending syncs so the imported expenses are sent to the user destinations
+	Sync bool
+}
+
+type ImportedRow struct {
+	RowNumber int
+	Expense   *database.Expense
+}
+
+type UnresolvedRow struct {
+	RowNumber int
+	Values    []string
+	Reason    string
+}
+
+type Result struct {
+	Imported   []ImportedRow
+	Unresolved []UnresolvedRow
+}
+
+type ImportService struct {
+	dbService               *database.DatabaseService
+	expenseRepo             *repository.ExpenseRepository
+	expenseSyncRepo         *repository.ExpenseSyncRepository
+	userPreferenceRepo      *repository.UserPreferenceRepository
+	expenseValidatorService *validator.ExpenseValidatorService
+	sheetsService           *sheets.SheetsService
+}
+
+func NewImportService(
+	dbService *database.DatabaseService,
+	expenseRepo *repository.ExpenseRepository,
+	expenseSyncRepo *repository.ExpenseSyncRepository,
+	userPreferenceRepo *repository.UserPreferenceRepository,
+	expenseValidatorService *validator.ExpenseValidatorService,
+	sheetsService *sheets.SheetsService,
+) *ImportService {
+	return &ImportService{
+		dbService:               dbService,
+		expenseRepo:             expenseRepo,
+		expenseSyncRepo:         expenseSyncRepo,
+		userPreferenceRepo:      userPreferenceRepo,
+		expenseValidatorService: expenseValidatorService,
+		sheetsService:           sheetsService,
+	}
+}
+
+/*
+Imports the expenses of a sheet for the user. Rows that cannot be resolved
+are returned in Result.Unresolved instead of being dropped. The resolved
+rows are inserted in a single transaction, so either all of them are
+imported or none is. Dates are read with the timezone and date format of the
+user, and rows without payment method get the default one of the user.
+*/
+func (s *ImportService) Import(ctx context.Context, userID uuid.UUID, opts *Options) (*Result, error) {
+	values, err := s.sheetsService.GetValues(opts.SheetID, opts.SheetName+"!A:G")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet: %w", err)
+	}
+
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	loc, err := dates.LoadLocation(preference.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	// Many rows share the same names, so each combination is resolved once
+	resolved := make(map[[3]string]*validator.ResolvedReferences)
+
+	for i, row := range values {
+		rowNumber := i + 1
+
+		if i == 0 && opts.HasHeader {
+			continue
+		}
+
+		if isEmpty(row) {
+			continue
+		}
+
+		expense, reason, err := s.parseRow(ctx, userID, row, preference, loc, resolved)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", rowNumber, err)
+		}
+
+		if reason != "" {
+			result.Unresolved = append(result.Unresolved, UnresolvedRow{RowNumber: rowNumber, Values: rowStrings(row), Reason: reason})
+			continue
+		}
+
+		result.Imported = append(result.Imported, ImportedRow{RowNumber: rowNumber, Expense: expense})
+	}
+
+	if opts.DryRun || len(result.Imported) == 0 {
+		return result, nil
+	}
+
+	if err := s.insert(ctx, userID, result.Imported, opts.Sync); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *ImportService) insert(ctx context.Context, userID uuid.UUID, rows []ImportedRow, sync bool) error {
+	tx, err := s.dbService.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, row := range rows {
+		id, err := s.expenseRepo.InsertWithTx(ctx, tx, row.Expense, nil, nil)
+		if err != nil {
+			return fmt.Errorf("failed to insert row %d: %w", row.RowNumber, err)
+		}
+		row.Expense.ID = id
+
+		if !sync {
+			continue
+		}
+
+		if err := s.expenseSyncRepo.InsertForUserDestinationsWithTx(ctx, tx, userID, id, database.SyncOperation_Insert); err != nil {
+			return fmt.Errorf("failed to insert expense sync for row %d: %w", row.RowNumber, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the reason why the row cannot be imported if it is not valid. Errors are only returned for unexpected failures
+func (s *ImportService) parseRow(ctx context.Context, userID uuid.UUID, row []interface{}, preference *database.UserPreference, loc *time.Location, resolved map[[3]string]*validator.ResolvedReferences) (*database.Expense, string, error) {
+	date, ok := sheets.CellDate(row, columnDate, preference.DateLayout(), loc)
+	if !ok {
+		return nil, fmt.Sprintf("invalid date '%s'", sheets.CellString(row, columnDate)), nil
+	}
+
+	description := sheets.CellString(row, columnDescription)
+	if description == "" {
+		return nil, "missing description", nil
+	}
+
+	arsAmount, ok := sheets.CellMoney(row, columnARS)
+	if !ok {
+		return nil, fmt.Sprintf("invalid ARS amount '%s'", sheets.CellString(row, columnARS)), nil
+	}
+
+	usdAmount, ok := sheets.CellMoney(row, columnUSD)
+	if !ok {
+		return nil, fmt.Sprintf("invalid USD amount '%s'", sheets.CellString(row, columnUSD)), nil
+	}
+
+	names := [3]string{
+		sheets.CellString(row, columnPaymentMethod),
+		sheets.CellString(row, columnCategory),
+		sheets.CellString(row, columnSubcategory),
+	}
+
+	refs, ok := resolved[names]
+	if !ok {
+		var err error
+		refs, err = s.expenseValidatorService.ResolveReferences(ctx, userID, names[0], names[1], names[2], preference.DefaultPaymentMethodID)
+
+		var validationErr *errors.ValidationError
+		if stdErrors.As(err, &validationErr) {
+			return nil, validationErr.Message, nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		resolved[names] = refs
+	}
+
+	expense := &database.Expense{
+		UserID:          userID,
+		Description:     description,
+		PaymentMethodID: refs.PaymentMethodID,
+		CategoryID:      refs.CategoryID,
+		SubcategoryID:   refs.SubcategoryID,
+		Date:            date,
+		Timezone:        preference.Timezone,
+	}
+	// The sheets only have ARS and USD amounts, so the expenses were paid in ARS
+	expense.SetAmount(database.ARSExpenseAmount(arsAmount, usdAmount, database.RateProvider_Import))
+
+	return expense, "", nil
+}
+
+func isEmpty(row []interface{}) bool {
+	for i := range row {
+		if sheets.CellString(row, i) != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func rowStrings(row []interface{}) []string {
+	values := make([]string, columnCount)
+	for i := range values {
+		values[i] = sheets.CellString(row, i)
+	}
+
+	return values
+}
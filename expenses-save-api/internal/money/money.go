@@ -0,0 +1,231 @@
+package money
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Decimals kept by Money
+const Scale = 2
+
+const unit = 100
+
+// Largest amount in hundredths that converts to and from float64 exactly
+const maxHundredths = 1 << 53
+
+/*
+Amount of money with Scale decimals, kept as an integer number of hundredths
+so adding and splitting it is exact. It is stored as Postgres numeric and
+marshalled to JSON as a number with two decimals. The zero value is 0.
+*/
+type Money struct {
+	hundredths int64
+}
+
+func FromHundredths(hundredths int64) Money {
+	return Money{hundredths: hundredths}
+}
+
+// Rounds f to two decimals, half away from zero. NaN and infinities are errors
+func FromFloat(f float64) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Money{}, fmt.Errorf("invalid amount %v", f)
+	}
+
+	// The shortest decimal of f, so 0.285 is rounded as typed and not as 0.28499...
+	return Parse(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+/*
+Parses a decimal like "1234.56", "-0.5" or "1e3". Digits after the second
+decimal are rounded half away from zero.
+*/
+func Parse(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+
+	// big.Rat also parses fractions like 1/3
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok || strings.Contains(value, "/") {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+
+	hundredths, ok := roundRat(rat.Mul(rat, big.NewRat(unit, 1)))
+	if !ok {
+		return Money{}, fmt.Errorf("amount %q is too large", value)
+	}
+
+	return Money{hundredths: hundredths}, nil
+}
+
+/*
+Amount sent both as an optional decimal string and as a double, like in the
+gRPC requests. The decimal is used when it is not empty.
+*/
+func FromDecimalOrFloat(decimal string, f float64) (Money, error) {
+	if decimal != "" {
+		return Parse(decimal)
+	}
+	return FromFloat(f)
+}
+
+func (m Money) Hundredths() int64 {
+	return m.hundredths
+}
+
+func (m Money) Float64() float64 {
+	return float64(m.hundredths) / unit
+}
+
+func (m Money) IsZero() bool {
+	return m.hundredths == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.hundredths < 0
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{hundredths: m.hundredths + other.hundredths}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{hundredths: m.hundredths - other.hundredths}
+}
+
+// -1, 0 or 1 when m is less than, equal to or greater than other
+func (m Money) Cmp(other Money) int {
+	switch {
+	case m.hundredths < other.hundredths:
+		return -1
+	case m.hundredths > other.hundredths:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Multiplies by a rate, rounding to two decimals
+func (m Money) Mul(rate float64) Money {
+	return Money{hundredths: int64(math.Round(float64(m.hundredths) * rate))}
+}
+
+// Divides by a rate, rounding to two decimals. rate cannot be zero
+func (m Money) Div(rate float64) Money {
+	return Money{hundredths: int64(math.Round(float64(m.hundredths) / rate))}
+}
+
+/*
+Splits the amount into n parts that add up to it. The parts are equal except
+the first one, which also gets the remainder, e.g. 100 in 3 is 33.34, 33.33
+and 33.33.
+*/
+func (m Money) Split(n int) []Money {
+	if n <= 0 {
+		return nil
+	}
+
+	part := m.hundredths / int64(n)
+	parts := make([]Money, n)
+	for i := range parts {
+		parts[i] = Money{hundredths: part}
+	}
+	parts[0].hundredths += m.hundredths - part*int64(n)
+
+	return parts
+}
+
+func (m Money) String() string {
+	sign := ""
+	hundredths := m.hundredths
+	if hundredths < 0 {
+		sign = "-"
+		hundredths = -hundredths
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, hundredths/unit, hundredths%unit)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Accepts a number or a string with one
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	parsed, err := Parse(strings.Trim(value, `"`))
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+func (m *Money) ScanNumeric(n pgtype.Numeric) error {
+	if !n.Valid {
+		return fmt.Errorf("cannot scan NULL into Money")
+	}
+
+	if n.NaN {
+		return fmt.Errorf("cannot scan NaN into Money")
+	}
+
+	if n.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("cannot scan infinity into Money")
+	}
+
+	rat := new(big.Rat).SetInt(n.Int)
+	exp := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(n.Exp))), nil))
+	if n.Exp < 0 {
+		rat.Quo(rat, exp)
+	} else {
+		rat.Mul(rat, exp)
+	}
+
+	hundredths, ok := roundRat(rat.Mul(rat, big.NewRat(unit, 1)))
+	if !ok {
+		return fmt.Errorf("numeric is too large for Money")
+	}
+
+	m.hundredths = hundredths
+	return nil
+}
+
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: big.NewInt(m.hundredths), Exp: -Scale, Valid: true}, nil
+}
+
+// Rounds half away from zero, false if it does not fit in an int64
+func roundRat(rat *big.Rat) (int64, bool) {
+	num := new(big.Int).Abs(rat.Num())
+	quo, rem := new(big.Int).QuoRem(num, rat.Denom(), new(big.Int))
+
+	if rem.Lsh(rem, 1).Cmp(rat.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(1))
+	}
+
+	if !quo.IsInt64() || quo.Int64() > maxHundredths {
+		return 0, false
+	}
+
+	if rat.Sign() < 0 {
+		return -quo.Int64(), true
+	}
+	return quo.Int64(), true
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,168 @@
+package money
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		hundredths int64
+		wantErr    bool
+	}{
+		{name: "two decimals", value: "1234.56", hundredths: 123456},
+		{name: "one decimal", value: "-0.5", hundredths: -50},
+		{name: "integer", value: "42", hundredths: 4200},
+		{name: "exponent", value: "1e3", hundredths: 100000},
+		{name: "surrounding spaces", value: " 10.10 ", hundredths: 1010},
+		{name: "half rounds up", value: "0.005", hundredths: 1},
+		{name: "below half rounds down", value: "0.004", hundredths: 0},
+		{name: "negative half rounds away from zero", value: "-0.005", hundredths: -1},
+		{name: "many decimals", value: "2.675000001", hundredths: 268},
+		{name: "fraction", value: "1/3", wantErr: true},
+		{name: "not a number", value: "abc", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+		{name: "too large", value: "1e20", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, err := Parse(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", amount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if amount.Hundredths() != tt.hundredths {
+				t.Errorf("hundredths = %d, want %d", amount.Hundredths(), tt.hundredths)
+			}
+		})
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      float64
+		hundredths int64
+		wantErr    bool
+	}{
+		{name: "two decimals", value: 1234.56, hundredths: 123456},
+		// Both are slightly below the half as float64
+		{name: "rounds as typed", value: 0.285, hundredths: 29},
+		{name: "rounds as typed above one", value: 1.005, hundredths: 101},
+		{name: "negative", value: -2.345, hundredths: -235},
+		{name: "zero", value: 0, hundredths: 0},
+		{name: "NaN", value: math.NaN(), wantErr: true},
+		{name: "infinity", value: math.Inf(1), wantErr: true},
+		{name: "negative infinity", value: math.Inf(-1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, err := FromFloat(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", amount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if amount.Hundredths() != tt.hundredths {
+				t.Errorf("hundredths = %d, want %d", amount.Hundredths(), tt.hundredths)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		hundredths int64
+		n          int
+		parts      []int64
+	}{
+		{name: "even", hundredths: 9000, n: 3, parts: []int64{3000, 3000, 3000}},
+		{name: "remainder on the first part", hundredths: 10000, n: 3, parts: []int64{3334, 3333, 3333}},
+		{name: "remainder of several cents", hundredths: 1000, n: 6, parts: []int64{170, 166, 166, 166, 166, 166}},
+		{name: "negative", hundredths: -10000, n: 3, parts: []int64{-3334, -3333, -3333}},
+		{name: "fewer cents than parts", hundredths: 2, n: 3, parts: []int64{2, 0, 0}},
+		{name: "one part", hundredths: 1234, n: 1, parts: []int64{1234}},
+		{name: "no parts", hundredths: 1234, n: 0, parts: nil},
+		{name: "negative parts", hundredths: 1234, n: -1, parts: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := FromHundredths(tt.hundredths).Split(tt.n)
+			if len(parts) != len(tt.parts) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.parts))
+			}
+
+			var total Money
+			for i, part := range parts {
+				if part.Hundredths() != tt.parts[i] {
+					t.Errorf("part %d = %d, want %d", i, part.Hundredths(), tt.parts[i])
+				}
+				total = total.Add(part)
+			}
+
+			if len(parts) > 0 && total.Hundredths() != tt.hundredths {
+				t.Errorf("parts add up to %d, want %d", total.Hundredths(), tt.hundredths)
+			}
+		})
+	}
+}
+
+func TestScanNumeric(t *testing.T) {
+	tests := []struct {
+		name       string
+		numeric    pgtype.Numeric
+		hundredths int64
+		wantErr    bool
+	}{
+		{name: "two decimals", numeric: pgtype.Numeric{Int: big.NewInt(12345), Exp: -2, Valid: true}, hundredths: 12345},
+		{name: "positive exponent", numeric: pgtype.Numeric{Int: big.NewInt(12), Exp: 1, Valid: true}, hundredths: 12000},
+		{name: "zero exponent", numeric: pgtype.Numeric{Int: big.NewInt(7), Exp: 0, Valid: true}, hundredths: 700},
+		{name: "more decimals rounds up", numeric: pgtype.Numeric{Int: big.NewInt(12345), Exp: -3, Valid: true}, hundredths: 1235},
+		{name: "more decimals rounds down", numeric: pgtype.Numeric{Int: big.NewInt(12344), Exp: -3, Valid: true}, hundredths: 1234},
+		{name: "negative rounds away from zero", numeric: pgtype.Numeric{Int: big.NewInt(-12345), Exp: -3, Valid: true}, hundredths: -1235},
+		{name: "NULL", numeric: pgtype.Numeric{}, wantErr: true},
+		{name: "NaN", numeric: pgtype.Numeric{NaN: true, Valid: true}, wantErr: true},
+		{name: "infinity", numeric: pgtype.Numeric{InfinityModifier: pgtype.Infinity, Valid: true}, wantErr: true},
+		{name: "too large", numeric: pgtype.Numeric{Int: big.NewInt(1), Exp: 20, Valid: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var amount Money
+			err := amount.ScanNumeric(tt.numeric)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", amount)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if amount.Hundredths() != tt.hundredths {
+				t.Errorf("hundredths = %d, want %d", amount.Hundredths(), tt.hundredths)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/destination"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/sheets"
+	"github.com/google/uuid"
+)
+
+// Columns written by destination.SheetsRow
+const sheetsColumns = "A:K"
+
+type Mismatch struct {
+	ExpenseID uuid.UUID
+	RowNumber int
+	Fields    []string
+}
+
+type ExtraRow struct {
+	ExpenseID uuid.UUID
+	RowNumber int
+}
+
+type Report struct {
+	DestinationID uuid.UUID
+	SheetID       string
+	SheetName     string
+	// Expenses in the database that are not in the sheet
+	Missing []uuid.UUID
+	// Rows in the sheet whose expense is not in the database, or is repeated
+	Extra []ExtraRow
+	// Expenses whose row differs from the database
+	Mismatched []Mismatch
+}
+
+func (r *Report) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Mismatched) == 0
+}
+
+type ReconcileService struct {
+	dbService     *database.DatabaseService
+	expenseRepo   *repository.ExpenseRepository
+	sheetsService *sheets.SheetsService
+}
+
+func NewReconcileService(dbService *database.DatabaseService, expenseRepo *repository.ExpenseRepository, sheetsService *sheets.SheetsService) *ReconcileService {
+	return &ReconcileService{dbService: dbService, expenseRepo: expenseRepo, sheetsService: sheetsService}
+}
+
+/*
+Compares every Google Sheets destination of the user with the expense table,
+matching rows by the expense ID in the first column. If repair is true the
+sheet is fixed afterwards: missing expenses are appended, mismatched rows are
+overwritten and extra rows are deleted.
+*/
+func (s *ReconcileService) Reconcile(ctx context.Context, userID uuid.UUID, repair bool) ([]*Report, error) {
+	saveDestinations, err := s.dbService.GetDestinationsByUserId(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destinations: %w", err)
+	}
+
+	expenses, err := s.expenseRepo.GetSheetsRowsByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expenses: %w", err)
+	}
+
+	var reports []*Report
+	for _, saveDestination := range saveDestinations {
+		if saveDestination.Destination != database.DestinationType_GoogleSheets {
+			continue
+		}
+
+		var config destination.GoogleSheetsConfig
+		if err := destination.DecodeConfig(saveDestination.Info, &config); err != nil {
+			return nil, fmt.Errorf("destination %s: %w", saveDestination.ID, err)
+		}
+
+		report, err := s.reconcileSheet(saveDestination.ID, &config, expenses, repair)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", saveDestination.ID, err)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func (s *ReconcileService) reconcileSheet(destinationID uuid.UUID, config *destination.GoogleSheetsConfig, expenses []database.ExpenseSheetsRow, repair bool) (*Report, error) {
+	values, err := s.sheetsService.GetValues(config.SheetID, config.SheetName+"!"+sheetsColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet: %w", err)
+	}
+
+	report := &Report{DestinationID: destinationID, SheetID: config.SheetID, SheetName: config.SheetName}
+
+	// Rows without an expense ID in the first column (headers, notes) are ignored
+	rowNumbers := make(map[uuid.UUID]int)
+	for i, row := range values {
+		if len(row) == 0 {
+			continue
+		}
+
+		id, err := uuid.Parse(sheets.CellString(row, 0))
+		if err != nil {
+			continue
+		}
+
+		if _, ok := rowNumbers[id]; ok {
+			report.Extra = append(report.Extra, ExtraRow{ExpenseID: id, RowNumber: i + 1})
+			continue
+		}
+
+		rowNumbers[id] = i + 1
+	}
+
+	known := make(map[uuid.UUID]bool, len(expenses))
+	for i := range expenses {
+		expense := &expenses[i]
+		known[expense.ID] = true
+
+		rowNumber, ok := rowNumbers[expense.ID]
+		if !ok {
+			report.Missing = append(report.Missing, expense.ID)
+			continue
+		}
+
+		expected, err := destination.SheetsRow(expense)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields := compareRow(expense, expected, values[rowNumber-1]); len(fields) > 0 {
+			report.Mismatched = append(report.Mismatched, Mismatch{ExpenseID: expense.ID, RowNumber: rowNumber, Fields: fields})
+		}
+	}
+
+	for id, rowNumber := range rowNumbers {
+		if !known[id] {
+			report.Extra = append(report.Extra, ExtraRow{ExpenseID: id, RowNumber: rowNumber})
+		}
+	}
+
+	sort.Slice(report.Extra, func(i, j int) bool {
+		return report.Extra[i].RowNumber < report.Extra[j].RowNumber
+	})
+
+	if repair {
+		if err := s.repair(config, report, expenses); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *ReconcileService) repair(config *destination.GoogleSheetsConfig, report *Report, expenses []database.ExpenseSheetsRow) error {
+	byID := make(map[uuid.UUID]*database.ExpenseSheetsRow, len(expenses))
+	for i := range expenses {
+		byID[expenses[i].ID] = &expenses[i]
+	}
+
+	for _, mismatch := range report.Mismatched {
+		row, err := destination.SheetsRow(byID[mismatch.ExpenseID])
+		if err != nil {
+			return err
+		}
+
+		if err := s.sheetsService.UpdateRow(config.SheetID, config.SheetName, mismatch.RowNumber, &row); err != nil {
+			return fmt.Errorf("failed to update row %d: %w", mismatch.RowNumber, err)
+		}
+	}
+
+	// From the bottom up, so deleting a row does not move the ones still to delete
+	for i := len(report.Extra) - 1; i >= 0; i-- {
+		extra := report.Extra[i]
+		if err := s.sheetsService.DeleteRow(config.SheetID, config.SheetName, extra.RowNumber); err != nil {
+			return fmt.Errorf("failed to delete row %d: %w", extra.RowNumber, err)
+		}
+	}
+
+	for _, id := range report.Missing {
+		row, err := destination.SheetsRow(byID[id])
+		if err != nil {
+			return err
+		}
+
+		if err := s.sheetsService.AppendRow(config.SheetID, config.SheetName, &row); err != nil {
+			return fmt.Errorf("failed to append expense %s: %w", id, err)
+		}
+	}
+
+	log.Printf("repaired sheet %s: %d appended, %d updated, %d deleted", config.SheetName, len(report.Missing), len(report.Mismatched), len(report.Extra))
+
+	return nil
+}
+
+// Returns the names of the fields that differ between the expense and the sheet row
+func compareRow(expense *database.ExpenseSheetsRow, expected []interface{}, row []interface{}) []string {
+	var fields []string
+
+	if date, ok := sheets.CellDate(row, 1, expense.DateLayout(), time.UTC); !ok || date.Format(expense.DateLayout()) != expected[1] {
+		fields = append(fields, "date")
+	}
+
+	if sheets.CellString(row, 2) != expense.Description {
+		fields = append(fields, "description")
+	}
+
+	if sheets.CellString(row, 3) != expense.PaymentMethodName {
+		fields = append(fields, "paymentMethod")
+	}
+
+	if !amountEqual(row, 4, expense.ARSAmount) {
+		fields = append(fields, "arsAmount")
+	}
+
+	if !amountEqual(row, 5, expense.USDAmount) {
+		fields = append(fields, "usdAmount")
+	}
+
+	if sheets.CellString(row, 6) != expense.CategoryName {
+		fields = append(fields, "category")
+	}
+
+	subcategoryName := ""
+	if expense.SubcategoryName != nil {
+		subcategoryName = *expense.SubcategoryName
+	}
+
+	if sheets.CellString(row, 7) != subcategoryName {
+		fields = append(fields, "subcategory")
+	}
+
+	if sheets.CellString(row, 9) != expense.Currency {
+		fields = append(fields, "currency")
+	}
+
+	if !amountEqual(row, 10, expense.OriginalAmount) {
+		fields = append(fields, "originalAmount")
+	}
+
+	return fields
+}
+
+// Cells that are not numbers never match, amounts have no NaN
+func amountEqual(row []interface{}, index int, amount money.Money) bool {
+	cell, ok := sheets.CellMoney(row, index)
+	return ok && cell == amount
+}
@@ -0,0 +1,380 @@
+package revaluation
+
+import (
+	"context"
+	stdErrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	expensesync "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/expenseSync"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type Options struct {
+	// Optional calendar days, YYYY-MM-DD or the date format of the user
+	StartDate string
+	EndDate   string
+	// Only the expenses whose ARS or USD amount is zero
+	OnlyInvalid bool
+	// Computes the changes without saving them
+	DryRun bool
+}
+
+// New amounts of an expense and the quote used to get them
+type Change struct {
+	ExpenseID     uuid.UUID         `json:"expenseId"`
+	Date          time.Time         `json:"date"`
+	Description   string            `json:"description"`
+	Currency      string            `json:"currency"`
+	OldARSAmount  money.Money       `json:"oldArsAmount"`
+	OldUSDAmount  money.Money       `json:"oldUsdAmount"`
+	ARSAmount     money.Money       `json:"arsAmount"`
+	USDAmount     money.Money       `json:"usdAmount"`
+	UsdArsRate    float64           `json:"usdArsRate"`
+	RateType      database.RateType `json:"rateType"`
+	RateProvider  string            `json:"rateProvider"`
+	RateFetchedAt time.Time         `json:"rateFetchedAt"`
+	// As it was before the revaluation
+	expense *database.Expense
+}
+
+type Skipped struct {
+	ExpenseID uuid.UUID `json:"expenseId"`
+	Reason    string    `json:"reason"`
+}
+
+type Result struct {
+	// Identifies the change records of the run, uuid.Nil in dry runs
+	RunID   uuid.UUID `json:"runId"`
+	DryRun  bool      `json:"dryRun"`
+	Changes []Change  `json:"changes"`
+	Skipped []Skipped `json:"skipped"`
+}
+
+type RevaluationService struct {
+	dbService              *database.DatabaseService
+	expenseRepo            *repository.ExpenseRepository
+	expenseRevaluationRepo *repository.ExpenseRevaluationRepository
+	expenseSyncRepo        *repository.ExpenseSyncRepository
+	paymentMethodRepo      *repository.PaymentMethodRepository
+	userPreferenceRepo     *repository.UserPreferenceRepository
+	dollarService          *dollar.DollarService
+	// nil when running as a command, the server sends the syncs when it polls
+	expenseSyncWorker *expensesync.ExpenseSyncWorker
+}
+
+func NewRevaluationService(
+	dbService *database.DatabaseService,
+	expenseRepo *repository.ExpenseRepository,
+	expenseRevaluationRepo *repository.ExpenseRevaluationRepository,
+	expenseSyncRepo *repository.ExpenseSyncRepository,
+	paymentMethodRepo *repository.PaymentMethodRepository,
+	userPreferenceRepo *repository.UserPreferenceRepository,
+	dollarService *dollar.DollarService,
+	expenseSyncWorker *expensesync.ExpenseSyncWorker,
+) *RevaluationService {
+	return &RevaluationService{
+		dbService:              dbService,
+		expenseRepo:            expenseRepo,
+		expenseRevaluationRepo: expenseRevaluationRepo,
+		expenseSyncRepo:        expenseSyncRepo,
+		paymentMethodRepo:      paymentMethodRepo,
+		userPreferenceRepo:     userPreferenceRepo,
+		dollarService:          dollarService,
+		expenseSyncWorker:      expenseSyncWorker,
+	}
+}
+
+/*
+Recomputes the ARS and USD amounts of the expenses of the user with the rate
+of the day of each expense, from the stored rates (see
+DollarService.GetQuoteAt). The amount the expense was paid in is kept and the
+other side is recomputed. When the original ARS amount is missing it is
+computed from the USD one instead. Expenses whose amounts were both entered
+(by the user, an import or before rate providers were recorded) are left as
+they are.
+
+Expenses of a past day without a stored rate within dollar.MaxRateGapDays
+of it are skipped, as are the ones edited while the run computes their
+amounts.
+
+Every changed expense gets an expense_revaluation row with the old and new
+amounts and a sync so the destinations are updated, all in one transaction.
+*/
+func (s *RevaluationService) Revalue(ctx context.Context, userID uuid.UUID, opts *Options) (*Result, error) {
+	if opts.StartDate == "" && opts.EndDate == "" && !opts.OnlyInvalid {
+		return nil, &errors.ValidationError{
+			Field:   "startDate",
+			Message: "a date range or onlyInvalid is required",
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	startDate, err := parseDate("startDate", opts.StartDate, preference)
+	if err != nil {
+		return nil, err
+	}
+
+	endDate, err := parseDate("endDate", opts.EndDate, preference)
+	if err != nil {
+		return nil, err
+	}
+
+	if startDate != nil && endDate != nil && startDate.After(*endDate) {
+		return nil, &errors.ValidationError{
+			Field:   "startDate",
+			Message: "startDate cannot be after endDate",
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	expenses, err := s.expenseRepo.GetForRevaluation(ctx, userID, startDate, endDate, opts.OnlyInvalid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expenses: %w", err)
+	}
+
+	result := &Result{DryRun: opts.DryRun, Changes: []Change{}, Skipped: []Skipped{}}
+	// Rate type override of each payment method
+	rateTypes := make(map[uuid.UUID]*database.RateType)
+
+	for i := range expenses {
+		expense := &expenses[i]
+
+		rateType, err := s.rateType(ctx, expense, preference, rateTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		quote, err := s.dollarService.GetQuoteAt(ctx, rateType, dates.In(expense.Date, expense.Timezone))
+		if err != nil {
+			// The day has no stored rate close enough to it
+			var validationErr *errors.ValidationError
+			if stdErrors.As(err, &validationErr) {
+				result.Skipped = append(result.Skipped, Skipped{ExpenseID: expense.ID, Reason: validationErr.Message})
+				continue
+			}
+			return nil, fmt.Errorf("failed to get rate of expense %s: %w", expense.ID, err)
+		}
+
+		change, reason := revalue(expense, quote)
+		if reason != "" {
+			result.Skipped = append(result.Skipped, Skipped{ExpenseID: expense.ID, Reason: reason})
+			continue
+		}
+
+		if change.ARSAmount == expense.ARSAmount && change.USDAmount == expense.USDAmount {
+			continue
+		}
+
+		result.Changes = append(result.Changes, *change)
+	}
+
+	if opts.DryRun || len(result.Changes) == 0 {
+		return result, nil
+	}
+
+	result.RunID = uuid.New()
+	if err := s.apply(ctx, userID, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *RevaluationService) apply(ctx context.Context, userID uuid.UUID, result *Result) error {
+	tx, err := s.dbService.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	expenseIDs := make([]uuid.UUID, 0, len(result.Changes))
+	applied := make([]Change, 0, len(result.Changes))
+
+	for i := range result.Changes {
+		change := &result.Changes[i]
+		expense := change.expense
+
+		updated := expense.Amount()
+		updated.ARSAmount = change.ARSAmount
+		updated.USDAmount = change.USDAmount
+		updated.UsdArsRate = change.UsdArsRate
+		updated.RateType = &change.RateType
+		updated.RateProvider = &change.RateProvider
+		updated.RateFetchedAt = &change.RateFetchedAt
+
+		switch expense.Currency {
+		case "ARS":
+			updated.OriginalAmount = change.ARSAmount
+			updated.CurrencyUsdRate = change.UsdArsRate
+		case "USD":
+			updated.CurrencyUsdRate = 1
+		}
+
+		if err := s.expenseRepo.UpdateAmountWithTx(ctx, tx, expense, updated); err != nil {
+			// Edited or deleted after it was read, the new amounts are not based on it
+			if stdErrors.Is(err, pgx.ErrNoRows) {
+				result.Skipped = append(result.Skipped, Skipped{ExpenseID: expense.ID, Reason: "changed during the revaluation"})
+				continue
+			}
+			return fmt.Errorf("failed to update expense %s: %w", expense.ID, err)
+		}
+
+		err := s.expenseRevaluationRepo.InsertWithTx(ctx, tx, &database.ExpenseRevaluation{
+			RunID:         result.RunID,
+			ExpenseID:     expense.ID,
+			UserID:        userID,
+			OldARSAmount:  expense.ARSAmount,
+			OldUSDAmount:  expense.USDAmount,
+			NewARSAmount:  change.ARSAmount,
+			NewUSDAmount:  change.USDAmount,
+			UsdArsRate:    change.UsdArsRate,
+			RateType:      change.RateType,
+			RateProvider:  change.RateProvider,
+			RateFetchedAt: change.RateFetchedAt,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to insert revaluation of expense %s: %w", expense.ID, err)
+		}
+
+		expenseIDs = append(expenseIDs, expense.ID)
+		applied = append(applied, *change)
+	}
+
+	result.Changes = applied
+	if len(applied) == 0 {
+		result.RunID = uuid.Nil
+		return nil
+	}
+
+	if err := s.expenseSyncRepo.InsertManyForUserDestinationsWithTx(ctx, tx, userID, expenseIDs, database.SyncOperation_Update); err != nil {
+		return fmt.Errorf("failed to insert expense syncs: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if s.expenseSyncWorker != nil {
+		s.expenseSyncWorker.Notify()
+	}
+
+	return nil
+}
+
+// The rate type of the expense if it was recorded, otherwise the one of its payment method or the user
+func (s *RevaluationService) rateType(ctx context.Context, expense *database.Expense, preference *database.UserPreference, rateTypes map[uuid.UUID]*database.RateType) (database.RateType, error) {
+	if expense.RateType != nil {
+		return *expense.RateType, nil
+	}
+
+	rateType, ok := rateTypes[expense.PaymentMethodID]
+	if !ok {
+		var err error
+		rateType, err = s.paymentMethodRepo.GetRateType(ctx, expense.PaymentMethodID, expense.UserID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get payment method rate type: %w", err)
+		}
+		rateTypes[expense.PaymentMethodID] = rateType
+	}
+
+	if rateType != nil {
+		return *rateType, nil
+	}
+
+	return preference.RateType, nil
+}
+
+// Returns the new amounts or the reason the expense cannot be revalued
+func revalue(expense *database.Expense, quote *dollar.Quote) (*Change, string) {
+	amount := &Change{
+		ExpenseID:     expense.ID,
+		Date:          expense.Date,
+		Description:   expense.Description,
+		Currency:      expense.Currency,
+		OldARSAmount:  expense.ARSAmount,
+		OldUSDAmount:  expense.USDAmount,
+		UsdArsRate:    quote.Rate,
+		RateType:      quote.Type,
+		RateProvider:  quote.Provider,
+		RateFetchedAt: quote.FetchedAt,
+		expense:       expense,
+	}
+
+	// Only converted amounts are recomputed. Without a rate provider (expenses
+	// created before they were recorded) both amounts were entered too
+	if known(expense.ARSAmount) && known(expense.USDAmount) && !converted(expense) {
+		return nil, "both amounts were entered"
+	}
+
+	switch expense.Currency {
+	case "ARS":
+		switch {
+		case known(expense.OriginalAmount):
+			amount.ARSAmount = expense.OriginalAmount
+			amount.USDAmount = expense.OriginalAmount.Div(quote.Rate)
+		case known(expense.USDAmount):
+			amount.USDAmount = expense.USDAmount
+			amount.ARSAmount = expense.USDAmount.Mul(quote.Rate)
+		default:
+			return nil, "ARS and USD amounts are missing"
+		}
+	case "USD":
+		if !known(expense.OriginalAmount) {
+			return nil, "USD amount is missing"
+		}
+		amount.USDAmount = expense.OriginalAmount
+		amount.ARSAmount = expense.OriginalAmount.Mul(quote.Rate)
+	default:
+		if !known(expense.OriginalAmount) || expense.CurrencyUsdRate == 0 {
+			return nil, fmt.Sprintf("%s amount or its USD rate is missing", expense.Currency)
+		}
+		amount.USDAmount = expense.OriginalAmount.Div(expense.CurrencyUsdRate)
+		amount.ARSAmount = expense.OriginalAmount.Mul(quote.Rate / expense.CurrencyUsdRate)
+	}
+
+	return amount, ""
+}
+
+func converted(expense *database.Expense) bool {
+	if expense.RateProvider == nil {
+		return false
+	}
+
+	provider := *expense.RateProvider
+	return provider != database.RateProvider_User && provider != database.RateProvider_Import
+}
+
+func parseDate(field string, value string, preference *database.UserPreference) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	// Only the calendar day is used, each expense is compared in its own timezone
+	date, err := dates.ParseDate(value, preference.DateLayout(), time.UTC)
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   field,
+			Message: fmt.Sprintf("invalid %s, expected YYYY-MM-DD or %s", field, preference.DateFormat),
+			Code:    int32(errors.InvalidDate),
+		}
+	}
+
+	return &date, nil
+}
+
+func known(amount money.Money) bool {
+	return !amount.IsZero()
+}
@@ -0,0 +1,68 @@
+package sheets
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
+)
+
+// Day zero of the serial numbers used by Google Sheets for dates
+var sheetsEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+/*
+Helpers to read the values returned by GetValues. The Sheets API omits
+trailing empty cells, so an index past the end of the row is an empty cell.
+*/
+
+func CellString(row []interface{}, index int) string {
+	if index >= len(row) || row[index] == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(fmt.Sprint(row[index]))
+}
+
+// Returns false if the cell is not a number
+func CellMoney(row []interface{}, index int) (money.Money, bool) {
+	if index >= len(row) {
+		return money.Money{}, false
+	}
+
+	var amount money.Money
+	var err error
+
+	switch v := row[index].(type) {
+	case float64:
+		amount, err = money.FromFloat(v)
+	case string:
+		amount, err = money.Parse(v)
+	default:
+		return money.Money{}, false
+	}
+
+	return amount, err == nil
+}
+
+// Reads a date cell as midnight in loc. Cells typed as YYYY-MM-DD or layout text are accepted too
+func CellDate(row []interface{}, index int, layout string, loc *time.Location) (time.Time, bool) {
+	if index >= len(row) {
+		return time.Time{}, false
+	}
+
+	switch v := row[index].(type) {
+	case float64:
+		d := sheetsEpoch.AddDate(0, 0, int(v))
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, loc), true
+	case string:
+		t, err := dates.ParseDate(strings.TrimSpace(v), layout, loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
@@ -3,6 +3,7 @@ package sheets
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"log"
 
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/env"
@@ -40,6 +41,172 @@ func (s *SheetsService) AppendRow(sheetID string, sheetName string, row *[]inter
 	return nil
 }
 
+// Appends all the rows with a single request
+func (s *SheetsService) AppendRows(sheetID string, sheetName string, rows [][]interface{}) error {
+	log.Printf("appending %d rows to sheet %s", len(rows), sheetName)
+
+	_, err := s.srv.Spreadsheets.Values.Append(sheetID, sheetName+"!A:A", &sheets.ValueRange{
+		Values: rows,
+	}).ValueInputOption("USER_ENTERED").Do()
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("rows appended successfully")
+	return nil
+}
+
+// Reads raw cell values. Numbers are returned as float64 and dates as serial numbers
+func (s *SheetsService) GetValues(sheetID string, readRange string) ([][]interface{}, error) {
+	resp, err := s.srv.Spreadsheets.Values.Get(sheetID, readRange).
+		ValueRenderOption("UNFORMATTED_VALUE").
+		DateTimeRenderOption("SERIAL_NUMBER").
+		Do()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Values, nil
+}
+
+// Returns the 1-based number of the row whose first column is id, or 0 if there is none
+func (s *SheetsService) FindRowByID(sheetID string, sheetName string, id string) (int, error) {
+	resp, err := s.srv.Spreadsheets.Values.Get(sheetID, sheetName+"!A:A").Do()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, row := range resp.Values {
+		if len(row) > 0 && fmt.Sprint(row[0]) == id {
+			return i + 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Same as FindRowByID for many IDs reading the column once. IDs that are not found are left out
+func (s *SheetsService) FindRowsByIDs(sheetID string, sheetName string, ids []string) (map[string]int, error) {
+	resp, err := s.srv.Spreadsheets.Values.Get(sheetID, sheetName+"!A:A").Do()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	rowNumbers := make(map[string]int)
+	for i, row := range resp.Values {
+		if len(row) == 0 {
+			continue
+		}
+
+		id := fmt.Sprint(row[0])
+		if _, found := rowNumbers[id]; wanted[id] && !found {
+			rowNumbers[id] = i + 1
+		}
+	}
+
+	return rowNumbers, nil
+}
+
+// Updates many rows, by their 1-based number, with a single request
+func (s *SheetsService) UpdateRows(sheetID string, sheetName string, rows map[int][]interface{}) error {
+	log.Printf("updating %d rows in sheet %s", len(rows), sheetName)
+
+	data := make([]*sheets.ValueRange, 0, len(rows))
+	for rowNumber, row := range rows {
+		data = append(data, &sheets.ValueRange{
+			Range:  fmt.Sprintf("%s!A%d", sheetName, rowNumber),
+			Values: [][]interface{}{row},
+		})
+	}
+
+	_, err := s.srv.Spreadsheets.Values.BatchUpdate(sheetID, &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             data,
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("rows updated successfully")
+	return nil
+}
+
+func (s *SheetsService) UpdateRow(sheetID string, sheetName string, rowNumber int, row *[]interface{}) error {
+	log.Printf("updating row %d in sheet %s: %v", rowNumber, sheetName, row)
+
+	_, err := s.srv.Spreadsheets.Values.Update(sheetID, fmt.Sprintf("%s!A%d", sheetName, rowNumber), &sheets.ValueRange{
+		Values: [][]interface{}{*row},
+	}).ValueInputOption("USER_ENTERED").Do()
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("row updated successfully")
+	return nil
+}
+
+/*
+Deletes the row, by its 1-based number, so the rows below it move up one.
+Clearing it would leave a blank row in the middle of the sheet
+*/
+func (s *SheetsService) DeleteRow(sheetID string, sheetName string, rowNumber int) error {
+	log.Printf("deleting row %d in sheet %s", rowNumber, sheetName)
+
+	gridID, err := s.gridID(sheetID, sheetName)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.srv.Spreadsheets.BatchUpdate(sheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{
+				DeleteDimension: &sheets.DeleteDimensionRequest{
+					Range: &sheets.DimensionRange{
+						SheetId:    gridID,
+						Dimension:  "ROWS",
+						StartIndex: int64(rowNumber - 1),
+						EndIndex:   int64(rowNumber),
+						// The first tab has ID 0, which is left out of the request otherwise
+						ForceSendFields: []string{"SheetId", "StartIndex"},
+					},
+				},
+			},
+		},
+	}).Do()
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("row deleted successfully")
+	return nil
+}
+
+// Numeric ID of the tab named sheetName, the structural requests use it instead of the name
+func (s *SheetsService) gridID(sheetID string, sheetName string) (int64, error) {
+	spreadsheet, err := s.srv.Spreadsheets.Get(sheetID).Fields("sheets.properties").Do()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == sheetName {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+
+	return 0, fmt.Errorf("sheet %s not found", sheetName)
+}
+
 func getConfig() (*jwt.Config, error) {
 	b, err := base64.StdEncoding.DecodeString(*env.CREDENTIALS_BASE64)
 
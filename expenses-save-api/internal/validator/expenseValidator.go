@@ -1,131 +1,394 @@
 package validator
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	categorymatcher "github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/categoryMatcher"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/database/repository"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dates"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/dollar"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/errors"
+	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/money"
 	"github.com/crisszkutnik/k8s-cluster-apps/expenses-save-api/internal/proto"
 	"github.com/google/uuid"
 )
 
 type ExpenseValidatorService struct {
-	dbService     *database.DatabaseService
-	dollarService *dollar.DollarService
+	referenceRepo      *repository.ReferenceRepository
+	paymentMethodRepo  *repository.PaymentMethodRepository
+	userPreferenceRepo *repository.UserPreferenceRepository
+	currencyRepo       *repository.CurrencyRepository
+	dollarService      *dollar.DollarService
+	categoryMatcher    *categorymatcher.CategoryMatcher
 }
 
-func NewExpenseValidatorService(dbService *database.DatabaseService, dollarService *dollar.DollarService) (*ExpenseValidatorService, error) {
-	return &ExpenseValidatorService{dbService: dbService, dollarService: dollarService}, nil
+func NewExpenseValidatorService(referenceRepo *repository.ReferenceRepository, paymentMethodRepo *repository.PaymentMethodRepository, userPreferenceRepo *repository.UserPreferenceRepository, currencyRepo *repository.CurrencyRepository, dollarService *dollar.DollarService, categoryMatcher *categorymatcher.CategoryMatcher) (*ExpenseValidatorService, error) {
+	return &ExpenseValidatorService{referenceRepo: referenceRepo, paymentMethodRepo: paymentMethodRepo, userPreferenceRepo: userPreferenceRepo, currencyRepo: currencyRepo, dollarService: dollarService, categoryMatcher: categoryMatcher}, nil
 }
 
-// TODO: Try to perform DB operation at the same time so it is faster. Maybe some subroutines and channels to sync
-func (s *ExpenseValidatorService) GetExpenseFromRequest(userID uuid.UUID, req *proto.NewExpenseRequest) (*database.Expense, error) {
-	paymentMethod, err := s.dbService.GetPaymentMethodByName(userID, req.ExpenseInfo.PaymentMethodName)
+type ResolvedReferences struct {
+	PaymentMethodID uuid.UUID
+	CategoryID      uuid.UUID
+	SubcategoryID   *uuid.UUID
+}
+
+/*
+Missing currency and payment method are taken from the preferences of the
+user, which also set the timezone and date format of the date.
+*/
+func (s *ExpenseValidatorService) GetExpenseFromRequest(ctx context.Context, userID uuid.UUID, req *proto.NewExpenseRequest) (*database.Expense, error) {
+	return s.getExpense(ctx, userID, req, nil)
+}
+
+/*
+Same as GetExpenseFromRequest for requests that do not choose a category, like
+the card notifications. The category rules of the user are applied first and
+the category of the request is only resolved, and required, when none of them
+matches the expense.
+*/
+func (s *ExpenseValidatorService) GetExpenseFromRequestWithRules(ctx context.Context, userID uuid.UUID, req *proto.NewExpenseRequest, vendor string) (*database.Expense, error) {
+	return s.getExpense(ctx, userID, req, &vendor)
+}
+
+// The category rules are only applied when vendor is not nil
+func (s *ExpenseValidatorService) getExpense(ctx context.Context, userID uuid.UUID, req *proto.NewExpenseRequest, vendor *string) (*database.Expense, error) {
+	preference, err := s.userPreferenceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch preferences: %w", err)
+	}
+
+	var refs *ResolvedReferences
+	if vendor == nil {
+		refs, err = s.ResolveReferences(ctx, userID, req.ExpenseInfo.PaymentMethodName, req.ExpenseInfo.CategoryName, req.ExpenseInfo.SubcategoryName, preference.DefaultPaymentMethodID)
+	} else {
+		// The category is left for the rules, which need the payment method
+		refs, err = s.resolvePaymentMethod(ctx, userID, req.ExpenseInfo.PaymentMethodName, preference.DefaultPaymentMethodID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if paymentMethod == nil {
-		return nil, &errors.ValidationError{
-			Field:   "paymentMethodName",
-			Message: fmt.Sprintf("payment method '%s' not found for user", req.ExpenseInfo.PaymentMethodName),
-			Code:    int32(errors.InvalidPaymentMethod),
-		}
+
+	date, timezone, err := s.parseDate(req, preference)
+	if err != nil {
+		return nil, err
 	}
 
-	category, err := s.dbService.GetCategoryByName(userID, req.ExpenseInfo.CategoryName)
+	rateType, err := s.getRateType(ctx, userID, refs.PaymentMethodID, preference)
 	if err != nil {
 		return nil, err
 	}
-	if category == nil {
-		return nil, &errors.ValidationError{
+
+	amount, err := s.parseAmount(ctx, req, preference, rateType, date)
+	if err != nil {
+		return nil, err
+	}
+
+	recurrentExpenseID, err := s.parseLinkage(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	expense := &database.Expense{
+		ID:                 uuid.Nil,
+		UserID:             userID,
+		Description:        req.ExpenseInfo.Name,
+		PaymentMethodID:    refs.PaymentMethodID,
+		CategoryID:         refs.CategoryID,
+		SubcategoryID:      refs.SubcategoryID,
+		RecurrentExpenseID: recurrentExpenseID,
+		Date:               date,
+		Timezone:           timezone,
+	}
+	expense.SetAmount(amount)
+
+	if vendor != nil {
+		if err := s.applyCategoryRules(ctx, expense, req, *vendor); err != nil {
+			return nil, err
+		}
+	}
+
+	return expense, nil
+}
+
+// Falls back to the category of the request when no rule matches
+func (s *ExpenseValidatorService) applyCategoryRules(ctx context.Context, expense *database.Expense, req *proto.NewExpenseRequest, vendor string) error {
+	rule, err := s.categoryMatcher.Apply(ctx, expense, vendor)
+	if err != nil {
+		return err
+	}
+	if rule != nil {
+		return nil
+	}
+
+	if req.ExpenseInfo.CategoryName == "" {
+		return &errors.ValidationError{
 			Field:   "categoryName",
-			Message: fmt.Sprintf("category '%s' not found for user", req.ExpenseInfo.CategoryName),
+			Message: "no category rule matches the expense and there is no default category",
 			Code:    int32(errors.InvalidCategory),
 		}
 	}
 
-	var subcategoryID *uuid.UUID
-	if req.ExpenseInfo.SubcategoryName != "" {
-		subcategory, err := s.dbService.GetSubcategoryByName(category.Id, req.ExpenseInfo.SubcategoryName)
-		if err != nil {
-			return nil, err
+	// The payment method is already resolved, it is passed as the default
+	refs, err := s.ResolveReferences(ctx, expense.UserID, "", req.ExpenseInfo.CategoryName, req.ExpenseInfo.SubcategoryName, &expense.PaymentMethodID)
+	if err != nil {
+		return err
+	}
+
+	expense.CategoryID = refs.CategoryID
+	expense.SubcategoryID = refs.SubcategoryID
+	return nil
+}
+
+// Maximum number of installments of a purchase
+const maxInstallmentMonths = 60
+
+/*
+Validates the optional recurrent expense and installments of the request and
+returns the ID of the recurrent expense, if any. The expense can be an
+occurrence of a recurrent expense or an installment purchase, not both.
+*/
+func (s *ExpenseValidatorService) parseLinkage(ctx context.Context, userID uuid.UUID, req *proto.NewExpenseRequest) (*uuid.UUID, error) {
+	months := req.ExpenseInfo.InstallmentMonths
+	if months < 0 || months > maxInstallmentMonths {
+		return nil, &errors.ValidationError{
+			Field:   "installmentMonths",
+			Message: fmt.Sprintf("installmentMonths has to be between 0 and %d, found %d", maxInstallmentMonths, months),
+			Code:    int32(errors.InvalidInstallments),
+		}
+	}
+
+	if req.ExpenseInfo.RecurrentExpenseId == "" {
+		return nil, nil
+	}
+
+	if months > 1 {
+		return nil, &errors.ValidationError{
+			Field:   "recurrentExpenseId",
+			Message: "an installment purchase cannot be an occurrence of a recurrent expense",
+			Code:    int32(errors.InvalidInstallments),
 		}
-		if subcategory == nil {
-			return nil, &errors.ValidationError{
-				Field:   "subcategoryName",
-				Message: fmt.Sprintf("subcategory '%s' not found for category '%s'", req.ExpenseInfo.SubcategoryName, req.ExpenseInfo.CategoryName),
-				Code:    int32(errors.InvalidSubcategory),
-			}
+	}
+
+	id, err := uuid.Parse(req.ExpenseInfo.RecurrentExpenseId)
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   "recurrentExpenseId",
+			Message: fmt.Sprintf("invalid recurrentExpenseId %s", req.ExpenseInfo.RecurrentExpenseId),
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	exists, err := s.referenceRepo.RecurrentExpenseExists(ctx, userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check recurrent expense: %w", err)
+	}
+	if !exists {
+		return nil, &errors.ValidationError{
+			Field:   "recurrentExpenseId",
+			Message: fmt.Sprintf("recurrent expense '%s' not found for user", id),
+			Code:    int32(errors.InvalidRecurrentExpense),
 		}
-		subcategoryID = &subcategory.Id
 	}
 
-	date, err := s.parseDate(req)
+	return &id, nil
+}
+
+const (
+	// Names below this similarity are not suggested
+	suggestionMinSimilarity = 0.3
+	// A similar name is used without asking only if it is this similar and clearly ahead of the next one
+	autoMatchMinSimilarity = 0.6
+	autoMatchMargin        = 0.15
+	maxSuggestions         = 5
+)
+
+/*
+Resolves the names of the payment method, category and subcategory (optional)
+of an expense into their IDs. Names are matched against the names and aliases
+of the user ignoring case and, if that fails, by similarity so typos still
+resolve. When there is no single good match the ValidationError includes the
+most similar names as suggestions.
+
+All the candidates are fetched in a single query that honors the context, so
+the deadline of the request also applies to it. defaultPaymentMethodID
+(optional) is used when paymentMethodName is empty.
+*/
+func (s *ExpenseValidatorService) ResolveReferences(ctx context.Context, userID uuid.UUID, paymentMethodName string, categoryName string, subcategoryName string, defaultPaymentMethodID *uuid.UUID) (*ResolvedReferences, error) {
+	candidates, err := s.referenceRepo.GetCandidates(ctx, userID, paymentMethodName, categoryName, subcategoryName, suggestionMinSimilarity, maxSuggestions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reference candidates: %w", err)
+	}
+
+	byKind := map[database.AliasKind][]database.ReferenceCandidate{}
+	for _, candidate := range candidates {
+		byKind[candidate.Kind] = append(byKind[candidate.Kind], candidate)
+	}
+
+	paymentMethodID, err := pickPaymentMethod(byKind[database.AliasKind_PaymentMethod], paymentMethodName, defaultPaymentMethodID)
 	if err != nil {
 		return nil, err
 	}
 
-	arsAmount, usdAmount, err := s.parseAmount(req)
+	categoryID, err := pickCandidate(byKind[database.AliasKind_Category], &errors.ValidationError{
+		Field:   "categoryName",
+		Message: fmt.Sprintf("category '%s' not found for user", categoryName),
+		Code:    int32(errors.InvalidCategory),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	expense := &database.Expense{
-		ID:              uuid.Nil,
-		UserID:          userID,
-		Description:     req.ExpenseInfo.Name,
-		PaymentMethodID: paymentMethod.Id,
-		ARSAmount:       arsAmount,
-		USDAmount:       usdAmount,
-		CategoryID:      category.Id,
-		SubcategoryID:   subcategoryID,
-		Date:            date,
+	var subcategoryID *uuid.UUID
+	if subcategoryName != "" {
+		id, err := pickCandidate(byKind[database.AliasKind_Subcategory], &errors.ValidationError{
+			Field:   "subcategoryName",
+			Message: fmt.Sprintf("subcategory '%s' not found for category '%s'", subcategoryName, categoryName),
+			Code:    int32(errors.InvalidSubcategory),
+		})
+		if err != nil {
+			return nil, err
+		}
+		subcategoryID = &id
 	}
 
-	return expense, nil
+	return &ResolvedReferences{
+		PaymentMethodID: paymentMethodID,
+		CategoryID:      categoryID,
+		SubcategoryID:   subcategoryID,
+	}, nil
 }
 
-func (s *ExpenseValidatorService) parseDate(req *proto.NewExpenseRequest) (time.Time, error) {
-	buenosAiresLoc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+// Same as ResolveReferences without the category and subcategory, which are left empty
+func (s *ExpenseValidatorService) resolvePaymentMethod(ctx context.Context, userID uuid.UUID, paymentMethodName string, defaultPaymentMethodID *uuid.UUID) (*ResolvedReferences, error) {
+	candidates, err := s.referenceRepo.GetCandidates(ctx, userID, paymentMethodName, "", "", suggestionMinSimilarity, maxSuggestions)
 	if err != nil {
-		return time.Time{}, err
+		return nil, fmt.Errorf("failed to get reference candidates: %w", err)
 	}
 
-	date, err := time.ParseInLocation("2006-01-02", req.ExpenseInfo.Date, buenosAiresLoc)
+	var paymentMethods []database.ReferenceCandidate
+	for _, candidate := range candidates {
+		if candidate.Kind == database.AliasKind_PaymentMethod {
+			paymentMethods = append(paymentMethods, candidate)
+		}
+	}
+
+	paymentMethodID, err := pickPaymentMethod(paymentMethods, paymentMethodName, defaultPaymentMethodID)
 	if err != nil {
-		return time.Time{}, &errors.ValidationError{
+		return nil, err
+	}
+
+	return &ResolvedReferences{PaymentMethodID: paymentMethodID}, nil
+}
+
+// defaultPaymentMethodID (optional) is used when paymentMethodName is empty
+func pickPaymentMethod(candidates []database.ReferenceCandidate, paymentMethodName string, defaultPaymentMethodID *uuid.UUID) (uuid.UUID, error) {
+	if paymentMethodName == "" && defaultPaymentMethodID != nil {
+		return *defaultPaymentMethodID, nil
+	}
+
+	return pickCandidate(candidates, &errors.ValidationError{
+		Field:   "paymentMethodName",
+		Message: fmt.Sprintf("payment method '%s' not found for user", paymentMethodName),
+		Code:    int32(errors.InvalidPaymentMethod),
+	})
+}
+
+/*
+Returns the exact candidate, or the most similar one if it is good enough.
+Otherwise returns notFound with the candidates as suggestions. Candidates have
+to be sorted, exact first and then by similarity.
+*/
+func pickCandidate(candidates []database.ReferenceCandidate, notFound *errors.ValidationError) (uuid.UUID, error) {
+	if len(candidates) > 0 {
+		best := candidates[0]
+
+		if best.Exact {
+			return best.ID, nil
+		}
+
+		if best.Similarity >= autoMatchMinSimilarity &&
+			(len(candidates) == 1 || best.Similarity-candidates[1].Similarity >= autoMatchMargin) {
+			return best.ID, nil
+		}
+	}
+
+	for _, candidate := range candidates {
+		notFound.Suggestions = append(notFound.Suggestions, candidate.Name)
+	}
+
+	if len(notFound.Suggestions) > 0 {
+		notFound.Message += fmt.Sprintf(", did you mean '%s'?", strings.Join(notFound.Suggestions, "', '"))
+	}
+
+	return uuid.Nil, notFound
+}
+
+func (s *ExpenseValidatorService) parseDate(req *proto.NewExpenseRequest, preference *database.UserPreference) (time.Time, string, error) {
+	date, timezone, err := dates.ParseExpenseDate(req.ExpenseInfo.Date, req.ExpenseInfo.Timezone, preference.Timezone, preference.DateLayout())
+	if err != nil {
+		return time.Time{}, "", &errors.ValidationError{
 			Field:   "date",
-			Message: fmt.Sprintf("invalid date format, expected YYYY-MM-DD found %s", req.ExpenseInfo.Date),
+			Message: err.Error(),
 			Code:    int32(errors.InvalidDate),
 		}
 	}
-	return date, nil
+	return date, timezone, nil
 }
 
-func (s *ExpenseValidatorService) parseAmount(req *proto.NewExpenseRequest) (float64, float64, error) {
-	usdToArs, err := s.dollarService.GetExchangeRate()
+// The payment method can override the rate type of the user
+func (s *ExpenseValidatorService) getRateType(ctx context.Context, userID uuid.UUID, paymentMethodID uuid.UUID, preference *database.UserPreference) (database.RateType, error) {
+	rateType, err := s.paymentMethodRepo.GetRateType(ctx, paymentMethodID, userID)
 	if err != nil {
-		return 0, 0, err
+		return "", fmt.Errorf("failed to get payment method rate type: %w", err)
+	}
+
+	if rateType != nil {
+		return *rateType, nil
 	}
 
-	var arsAmount, usdAmount float64
+	return preference.RateType, nil
+}
 
-	switch req.ExpenseInfo.Currency {
-	case "ARS":
-		arsAmount = req.ExpenseInfo.Amount
-		usdAmount = req.ExpenseInfo.Amount / usdToArs
-	case "USD":
-		arsAmount = req.ExpenseInfo.Amount * usdToArs
-		usdAmount = req.ExpenseInfo.Amount
-	default:
-		return 0, 0, &errors.ValidationError{
+// Converts the amount with the rate of the day of the expense
+func (s *ExpenseValidatorService) parseAmount(ctx context.Context, req *proto.NewExpenseRequest, preference *database.UserPreference, rateType database.RateType, date time.Time) (*database.ExpenseAmount, error) {
+	currency := strings.ToUpper(req.ExpenseInfo.Currency)
+	if currency == "" {
+		currency = preference.DefaultCurrency
+	}
+
+	exists, err := s.currencyRepo.Exists(ctx, currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check currency: %w", err)
+	}
+
+	if !exists {
+		return nil, &errors.ValidationError{
 			Field:   "currency",
-			Message: fmt.Sprintf("unsupported currency: %s (supported: ARS, USD)", req.ExpenseInfo.Currency),
+			Message: fmt.Sprintf("unsupported currency: %s", currency),
 			Code:    int32(errors.InvalidCurrency),
 		}
 	}
 
-	return arsAmount, usdAmount, nil
+	amount, err := money.FromDecimalOrFloat(req.ExpenseInfo.AmountDecimal, req.ExpenseInfo.Amount)
+	if err != nil {
+		return nil, &errors.ValidationError{
+			Field:   "amount",
+			Message: fmt.Sprintf("invalid amount: %v", err),
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	if amount.IsNegative() {
+		return nil, &errors.ValidationError{
+			Field:   "amount",
+			Message: "amount cannot be negative",
+			Code:    int32(errors.InvalidPayload),
+		}
+	}
+
+	return s.dollarService.Convert(ctx, currency, amount, rateType, date)
 }
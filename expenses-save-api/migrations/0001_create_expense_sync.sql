@@ -0,0 +1,18 @@
+-- Outbox of expenses that still have to be delivered to the user's
+-- destinations (user_expense_save). One row per expense and destination.
+CREATE TABLE IF NOT EXISTS public.expense_sync (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    expense_id uuid NOT NULL REFERENCES public.expense (id) ON DELETE CASCADE,
+    user_id uuid NOT NULL,
+    destination_id uuid NOT NULL REFERENCES public.user_expense_save (id) ON DELETE CASCADE,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    last_error text,
+    next_attempt_date timestamptz NOT NULL DEFAULT now(),
+    created_date timestamptz NOT NULL DEFAULT now(),
+    updated_date timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS expense_sync_pending_idx
+    ON public.expense_sync (next_attempt_date)
+    WHERE status = 'pending';
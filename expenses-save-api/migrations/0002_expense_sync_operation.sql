@@ -0,0 +1,7 @@
+-- Syncs are also created for updates and deletes. Deletes must outlive the
+-- expense they refer to, so expense_id no longer references public.expense.
+ALTER TABLE public.expense_sync
+    ADD COLUMN IF NOT EXISTS operation text NOT NULL DEFAULT 'insert';
+
+ALTER TABLE public.expense_sync
+    DROP CONSTRAINT IF EXISTS expense_sync_expense_id_fkey;
@@ -0,0 +1,7 @@
+-- Key supplied by clients so retried requests do not create duplicated expenses
+ALTER TABLE public.expense
+    ADD COLUMN IF NOT EXISTS idempotency_key text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS expense_user_idempotency_key_idx
+    ON public.expense (user_id, idempotency_key)
+    WHERE idempotency_key IS NOT NULL;
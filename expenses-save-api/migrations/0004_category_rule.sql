@@ -0,0 +1,25 @@
+-- Per-user rules that assign a category to incoming expenses (e.g. card
+-- notifications, which only carry the vendor). Rules with a higher priority
+-- are evaluated first and the first match wins.
+CREATE TABLE IF NOT EXISTS public.category_rule (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    name text NOT NULL,
+    priority integer NOT NULL DEFAULT 0,
+    field text NOT NULL DEFAULT 'description',
+    match_type text NOT NULL DEFAULT 'substring',
+    pattern text NOT NULL,
+    min_amount double precision,
+    max_amount double precision,
+    payment_method_id uuid REFERENCES public.payment_method (id) ON DELETE CASCADE,
+    category_id uuid NOT NULL REFERENCES public.category (id) ON DELETE CASCADE,
+    subcategory_id uuid REFERENCES public.subcategory (id) ON DELETE SET NULL,
+    normalized_description text,
+    enabled boolean NOT NULL DEFAULT true,
+    created_date timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT category_rule_field_check CHECK (field IN ('description', 'vendor')),
+    CONSTRAINT category_rule_match_type_check CHECK (match_type IN ('substring', 'regex'))
+);
+
+CREATE INDEX IF NOT EXISTS category_rule_user_idx
+    ON public.category_rule (user_id, priority DESC);
@@ -0,0 +1,32 @@
+-- Alternative names users can send instead of the name of a category,
+-- subcategory or payment method (e.g. "super" for "Supermercado")
+CREATE TABLE IF NOT EXISTS public.name_alias (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    kind text NOT NULL,
+    alias text NOT NULL,
+    target_id uuid NOT NULL,
+    created_date timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT name_alias_kind_check CHECK (kind IN ('category', 'subcategory', 'payment_method'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS name_alias_user_kind_alias_idx
+    ON public.name_alias (user_id, kind, LOWER(alias));
+
+CREATE INDEX IF NOT EXISTS name_alias_target_idx
+    ON public.name_alias (target_id);
+
+-- Similarity matching for names that do not match exactly (typos)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS category_name_trgm_idx
+    ON public.category USING gin (name gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS subcategory_name_trgm_idx
+    ON public.subcategory USING gin (name gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS payment_method_name_trgm_idx
+    ON public.payment_method USING gin (name gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS name_alias_alias_trgm_idx
+    ON public.name_alias USING gin (alias gin_trgm_ops);
@@ -0,0 +1,7 @@
+-- Timezone where the expense happened, used to know its calendar day.
+-- Existing expenses were all parsed as Buenos Aires dates
+ALTER TABLE public.expense
+    ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT 'America/Argentina/Buenos_Aires';
+
+CREATE INDEX IF NOT EXISTS expense_user_local_date_idx
+    ON public.expense (user_id, ((date AT TIME ZONE timezone)::date));
@@ -0,0 +1,11 @@
+-- Preferences of each user. Users without a row use the defaults
+CREATE TABLE IF NOT EXISTS public.user_preference (
+    user_id uuid PRIMARY KEY,
+    timezone text NOT NULL DEFAULT 'America/Argentina/Buenos_Aires',
+    default_currency text NOT NULL DEFAULT 'ARS',
+    default_payment_method_id uuid REFERENCES public.payment_method (id) ON DELETE SET NULL,
+    date_format text NOT NULL DEFAULT 'YYYY-MM-DD',
+    updated_date timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT user_preference_currency_check CHECK (default_currency IN ('ARS', 'USD')),
+    CONSTRAINT user_preference_date_format_check CHECK (date_format IN ('YYYY-MM-DD', 'DD/MM/YYYY', 'MM/DD/YYYY'))
+);
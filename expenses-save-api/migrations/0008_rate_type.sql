@@ -0,0 +1,19 @@
+-- USD/ARS rate used to convert the expenses of the user. Payment methods can
+-- override it (e.g. cards are charged at the card rate)
+ALTER TABLE public.user_preference
+    ADD COLUMN IF NOT EXISTS rate_type text NOT NULL DEFAULT 'mep';
+
+ALTER TABLE public.user_preference
+    DROP CONSTRAINT IF EXISTS user_preference_rate_type_check;
+
+ALTER TABLE public.user_preference
+    ADD CONSTRAINT user_preference_rate_type_check CHECK (rate_type IN ('mep', 'ccl', 'official', 'card'));
+
+ALTER TABLE public.payment_method
+    ADD COLUMN IF NOT EXISTS rate_type text;
+
+ALTER TABLE public.payment_method
+    DROP CONSTRAINT IF EXISTS payment_method_rate_type_check;
+
+ALTER TABLE public.payment_method
+    ADD CONSTRAINT payment_method_rate_type_check CHECK (rate_type IN ('mep', 'ccl', 'official', 'card'));
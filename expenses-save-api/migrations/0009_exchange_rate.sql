@@ -0,0 +1,14 @@
+-- Daily USD/ARS rates of each rate type, filled by the exchange rate collector.
+-- The row of a day is overwritten until the day ends, so it keeps the last rate
+-- of the day. Days are Buenos Aires days
+CREATE TABLE IF NOT EXISTS public.exchange_rate (
+    rate_type text NOT NULL,
+    date date NOT NULL,
+    rate double precision NOT NULL,
+    provider text NOT NULL,
+    components jsonb NOT NULL DEFAULT '{}',
+    fetched_at timestamptz NOT NULL,
+    PRIMARY KEY (rate_type, date),
+    CONSTRAINT exchange_rate_rate_type_check CHECK (rate_type IN ('mep', 'ccl', 'official', 'card')),
+    CONSTRAINT exchange_rate_rate_check CHECK (rate > 0)
+);
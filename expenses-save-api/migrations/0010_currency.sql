@@ -0,0 +1,42 @@
+-- Currencies expenses can be paid in. Amounts are always converted to ARS and
+-- USD too, so every currency needs a rate against USD
+CREATE TABLE IF NOT EXISTS public.currency (
+    code text PRIMARY KEY,
+    name text NOT NULL,
+    CONSTRAINT currency_code_check CHECK (code ~ '^[A-Z]{3}$')
+);
+
+INSERT INTO public.currency (code, name) VALUES
+    ('ARS', 'Argentine peso'),
+    ('USD', 'US dollar'),
+    ('EUR', 'Euro'),
+    ('BRL', 'Brazilian real'),
+    ('CLP', 'Chilean peso')
+ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE public.user_preference
+    DROP CONSTRAINT IF EXISTS user_preference_currency_check,
+    DROP CONSTRAINT IF EXISTS user_preference_currency_fkey;
+
+ALTER TABLE public.user_preference
+    ADD CONSTRAINT user_preference_currency_fkey FOREIGN KEY (default_currency) REFERENCES public.currency (code);
+
+-- Amount in the currency the expense was paid in and the rates used to get
+-- ars_amount and usd_amount. usd_ars_rate is ARS per USD and currency_usd_rate
+-- units of the currency per USD. Existing expenses were all paid in ARS, their
+-- rates are unknown when usd_amount is missing
+ALTER TABLE public.expense
+    ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'ARS' REFERENCES public.currency (code),
+    ADD COLUMN IF NOT EXISTS original_amount double precision,
+    ADD COLUMN IF NOT EXISTS usd_ars_rate double precision,
+    ADD COLUMN IF NOT EXISTS currency_usd_rate double precision;
+
+UPDATE public.expense
+SET
+    original_amount = ars_amount,
+    usd_ars_rate = CASE WHEN usd_amount = 'NaN' OR usd_amount = 0 THEN NULL ELSE ars_amount / usd_amount END,
+    currency_usd_rate = CASE WHEN usd_amount = 'NaN' OR usd_amount = 0 THEN NULL ELSE ars_amount / usd_amount END
+WHERE original_amount IS NULL;
+
+ALTER TABLE public.expense
+    ALTER COLUMN original_amount SET NOT NULL;
@@ -0,0 +1,14 @@
+-- Where the USD/ARS rate of each expense came from, to audit its conversion.
+-- rate_provider is the provider of the quote (e.g. stock_market, dolarapi),
+-- user when both amounts were entered by the user or import when they came
+-- from an imported sheet. Expenses created before are NULL
+ALTER TABLE public.expense
+    ADD COLUMN IF NOT EXISTS rate_type text,
+    ADD COLUMN IF NOT EXISTS rate_provider text,
+    ADD COLUMN IF NOT EXISTS rate_fetched_at timestamptz;
+
+ALTER TABLE public.expense
+    DROP CONSTRAINT IF EXISTS expense_rate_type_check;
+
+ALTER TABLE public.expense
+    ADD CONSTRAINT expense_rate_type_check CHECK (rate_type IN ('mep', 'ccl', 'official', 'card'));
@@ -0,0 +1,24 @@
+-- Changes made by the revaluation of the ARS and USD amounts of expenses, one
+-- row per expense and run. Old amounts keep NaN when that is what was stored.
+-- Rows are kept when the expense is deleted
+CREATE TABLE IF NOT EXISTS public.expense_revaluation (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    run_id uuid NOT NULL,
+    expense_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    old_ars_amount double precision NOT NULL,
+    old_usd_amount double precision NOT NULL,
+    new_ars_amount double precision NOT NULL,
+    new_usd_amount double precision NOT NULL,
+    usd_ars_rate double precision NOT NULL,
+    rate_type text NOT NULL,
+    rate_provider text NOT NULL,
+    rate_fetched_at timestamptz NOT NULL,
+    created_date timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS expense_revaluation_expense_idx
+    ON public.expense_revaluation (expense_id);
+
+CREATE INDEX IF NOT EXISTS expense_revaluation_run_idx
+    ON public.expense_revaluation (run_id);
@@ -0,0 +1,48 @@
+-- Amounts are kept with two decimals instead of as floating point. NaN amounts
+-- become 0, `revalue -invalid` recomputes them from the other side when it is
+-- known. Rates stay double precision, but without NaN
+UPDATE public.expense SET ars_amount = 0 WHERE ars_amount = 'NaN';
+UPDATE public.expense SET usd_amount = 0 WHERE usd_amount = 'NaN';
+UPDATE public.expense SET original_amount = 0 WHERE original_amount = 'NaN';
+UPDATE public.expense SET usd_ars_rate = NULL WHERE usd_ars_rate = 'NaN';
+UPDATE public.expense SET currency_usd_rate = NULL WHERE currency_usd_rate = 'NaN';
+
+ALTER TABLE public.expense
+    ALTER COLUMN ars_amount TYPE numeric(16, 2) USING round(ars_amount::numeric, 2),
+    ALTER COLUMN usd_amount TYPE numeric(16, 2) USING round(usd_amount::numeric, 2),
+    ALTER COLUMN original_amount TYPE numeric(16, 2) USING round(original_amount::numeric, 2);
+
+-- numeric(16, 2) still accepts NaN
+ALTER TABLE public.expense
+    DROP CONSTRAINT IF EXISTS expense_amount_nan_check;
+
+ALTER TABLE public.expense
+    ADD CONSTRAINT expense_amount_nan_check CHECK (
+        ars_amount <> 'NaN' AND usd_amount <> 'NaN' AND original_amount <> 'NaN'
+    );
+
+UPDATE public.recurrent_expense SET ars_amount = NULL WHERE ars_amount = 'NaN';
+UPDATE public.recurrent_expense SET usd_amount = NULL WHERE usd_amount = 'NaN';
+
+ALTER TABLE public.recurrent_expense
+    ALTER COLUMN ars_amount TYPE numeric(16, 2) USING round(ars_amount::numeric, 2),
+    ALTER COLUMN usd_amount TYPE numeric(16, 2) USING round(usd_amount::numeric, 2);
+
+ALTER TABLE public.recurrent_expense
+    DROP CONSTRAINT IF EXISTS recurrent_expense_amount_nan_check;
+
+ALTER TABLE public.recurrent_expense
+    ADD CONSTRAINT recurrent_expense_amount_nan_check CHECK (ars_amount <> 'NaN' AND usd_amount <> 'NaN');
+
+ALTER TABLE public.category_rule
+    ALTER COLUMN min_amount TYPE numeric(16, 2) USING round(min_amount::numeric, 2),
+    ALTER COLUMN max_amount TYPE numeric(16, 2) USING round(max_amount::numeric, 2);
+
+UPDATE public.expense_revaluation SET old_ars_amount = 0 WHERE old_ars_amount = 'NaN';
+UPDATE public.expense_revaluation SET old_usd_amount = 0 WHERE old_usd_amount = 'NaN';
+
+ALTER TABLE public.expense_revaluation
+    ALTER COLUMN old_ars_amount TYPE numeric(16, 2) USING round(old_ars_amount::numeric, 2),
+    ALTER COLUMN old_usd_amount TYPE numeric(16, 2) USING round(old_usd_amount::numeric, 2),
+    ALTER COLUMN new_ars_amount TYPE numeric(16, 2) USING round(new_ars_amount::numeric, 2),
+    ALTER COLUMN new_usd_amount TYPE numeric(16, 2) USING round(new_usd_amount::numeric, 2);
@@ -0,0 +1,21 @@
+-- Calendar day of the expense in its timezone, computed by the app when the
+-- expense is saved. Postgres reads offsets like -03:00 as POSIX zones, which
+-- have the sign inverted, so (date AT TIME ZONE timezone)::date put those
+-- expenses up to a day off
+ALTER TABLE public.expense
+    ADD COLUMN IF NOT EXISTS local_date date;
+
+UPDATE public.expense
+SET local_date = CASE
+    WHEN timezone ~ '^[+-]\d{2}:\d{2}$' THEN ((date AT TIME ZONE 'UTC') + timezone::interval)::date
+    ELSE (date AT TIME ZONE timezone)::date
+END
+WHERE local_date IS NULL;
+
+ALTER TABLE public.expense
+    ALTER COLUMN local_date SET NOT NULL;
+
+DROP INDEX IF EXISTS public.expense_user_local_date_idx;
+
+CREATE INDEX IF NOT EXISTS expense_user_local_date_idx
+    ON public.expense (user_id, local_date);
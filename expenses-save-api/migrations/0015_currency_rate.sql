@@ -0,0 +1,11 @@
+-- Daily rates of the currencies other than ARS and USD, in units per USD, filled
+-- by the exchange rate collector like exchange_rate. Past expenses in those
+-- currencies are converted with the rate of their day
+CREATE TABLE IF NOT EXISTS public.currency_rate (
+    currency text NOT NULL REFERENCES public.currency (code),
+    date date NOT NULL,
+    rate double precision NOT NULL,
+    fetched_at timestamptz NOT NULL,
+    PRIMARY KEY (currency, date),
+    CONSTRAINT currency_rate_rate_check CHECK (rate > 0)
+);